@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// errInvalidConfig is returned by check-config when problems were found
+var errInvalidConfig = errors.New("invalid config")
+
+// checkConfigCommand checks the config file for problems without starting the
+// exporter, for use in deploy pipelines
+type checkConfigCommand struct {
+	cmd *kingpin.CmdClause
+}
+
+func newCheckConfigCommand() *checkConfigCommand {
+	c := &checkConfigCommand{}
+
+	c.cmd = kingpin.Command("check-config", "Check the config file (and the web config file, if set) for problems, and exit.")
+
+	return c
+}
+
+// run checks the config files, listing each problem found in out. Password
+// files and commands are read and run, just as when the exporter starts.
+func (c *checkConfigCommand) run(out io.Writer, configFile, webConfigFile string) error {
+	var problems []string
+
+	f, err := os.Open(configFile)
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		defer f.Close()
+
+		if _, err := parse(f); err != nil {
+			problems = append(problems, strings.Split(err.Error(), "\n")...)
+		}
+	}
+
+	if webConfigFile != "" {
+		if _, err := loadWebConfig(webConfigFile); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", webConfigFile, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(out, "found %d problem(s):\n", len(problems))
+
+		for _, p := range problems {
+			fmt.Fprintf(out, "  - %s\n", p)
+		}
+
+		return errInvalidConfig
+	}
+
+	fmt.Fprintf(out, "%s is valid\n", configFile)
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hitron_coda.yml")
+
+	c := newCheckConfigCommand()
+
+	require.NoError(t, os.WriteFile(path, []byte(minimalConfig), 0o600))
+
+	var out bytes.Buffer
+
+	require.NoError(t, c.run(&out, path, ""))
+	assert.Contains(t, out.String(), "is valid")
+
+	require.NoError(t, os.WriteFile(path, []byte("username: user\npasswrod: pass\n"), 0o600))
+
+	webConfig := filepath.Join(dir, "web.yml")
+	require.NoError(t, os.WriteFile(webConfig, []byte("basic_auth_users: {alice: password}\n"), 0o600))
+
+	out.Reset()
+
+	err := c.run(&out, path, webConfig)
+	require.ErrorIs(t, err, errInvalidConfig)
+	assert.Contains(t, out.String(), "found 4 problem(s):\n")
+	assert.Contains(t, out.String(), "  - line 2: passwrod: unknown field\n")
+	assert.Contains(t, out.String(), "  - host: must be set, unless targets are configured\n")
+	assert.Contains(t, out.String(), `  - `+webConfig+`: invalid bcrypt hash for user "alice"`)
+
+	out.Reset()
+
+	err = c.run(&out, filepath.Join(dir, "missing.yml"), "")
+	require.ErrorIs(t, err, errInvalidConfig)
+	assert.Contains(t, out.String(), "found 1 problem(s):\n")
+}
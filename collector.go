@@ -2,50 +2,97 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"sync"
+	"time"
 
-	hitron "github.com/hairyhenderson/hitron_coda"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-type collector struct {
-	ctx    context.Context
-	client *hitron.CableModem
-	rc     routerCollector
-	cc     cmCollector
-	wc     wifiCollector
-
-	up prometheus.Gauge
+// errNotCollected is the error for API calls that were never made, for example
+// because the scrape was cancelled first
+var errNotCollected = errors.New("not collected")
 
-	config config
+type collector struct {
+	ctx     context.Context
+	session *session
+	rc      routerCollector
+	cc      cmCollector
+	wc      wifiCollector
+	lc      logCollector
+	dc      docsisCollector
+	nc      lanCollector
+	state   *targetState
+	resets  resetMetrics
+
+	up                prometheus.Gauge
+	sessionAge        prometheus.Gauge
+	collectorDuration *prometheus.GaugeVec
+	collectorSuccess  *prometheus.GaugeVec
+
+	target *scrapeTarget
+	// failures holds the errors from the last scrape's failed API calls, keyed
+	// by collector
+	failures map[string]string
+	// reachable is set once the device has been successfully scraped
+	reachable bool
+	// concurrency is the maximum number of concurrent API calls
+	concurrency int
 }
 
-func newCollector(ctx context.Context, conf config) *collector {
-	c := &collector{ctx: ctx, config: conf}
-	c.rc = newRouterCollector(ctx, c.getClient)
-	c.cc = newCMCollector(ctx, c.getClient)
-	c.wc = newWiFiCollector(ctx, c.getClient)
+func newCollector(ctx context.Context, target *scrapeTarget, concurrency int) *collector {
+	c := &collector{ctx: ctx, target: target, session: sessions.get(target), concurrency: concurrency}
+	state := states.get(target.Host)
+	c.state = state
+	c.resets = newResetMetrics()
+	c.rc = newRouterCollector(ctx, c.session, target.Location, state)
+	c.cc = newCMCollector(ctx, c.session, target.SignalThresholds, state)
+	c.wc = newWiFiCollector(ctx, c.session, state, target.WiFiClients, target.hashKey)
+	c.lc = newLogCollector(ctx, c.session, state)
+	c.dc = newDocsisCollector(ctx, c.session)
+	c.nc = newLANCollector(ctx, c.session, target.LANHosts, target.hashKey)
 
 	c.up = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: metricsNS,
 		Name:      "up",
 		Help:      "Whether the device is reachable (1), or not (0)",
 	})
+	c.sessionAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Name:      "session_age_seconds",
+		Help:      "Time since the exporter logged in to the device, in seconds",
+	})
+	c.collectorDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: "scrape",
+		Name:      "collector_duration_seconds",
+		Help:      "Duration of each collector's API call, in seconds",
+	}, []string{"collector"})
+	c.collectorSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: "scrape",
+		Name:      "collector_success",
+		Help:      "Whether each collector's API call succeeded (1), or not (0)",
+	}, []string{"collector"})
 
 	return c
 }
 
-func (c *collector) getClient() *hitron.CableModem {
-	return c.client
-}
-
 // Describe implements Prometheus.Collector.
 func (c collector) Describe(ch chan<- *prometheus.Desc) {
 	c.rc.Describe(ch)
 	c.cc.Describe(ch)
 	c.wc.Describe(ch)
+	c.lc.Describe(ch)
+	c.dc.Describe(ch)
+	c.nc.Describe(ch)
+	c.resets.Describe(ch)
 
 	c.up.Describe(ch)
+	c.sessionAge.Describe(ch)
+	c.collectorDuration.Describe(ch)
+	c.collectorSuccess.Describe(ch)
 }
 
 // Collect implements Prometheus.Collector.
@@ -54,30 +101,139 @@ func (c *collector) Collect(ch chan<- prometheus.Metric) {
 	c.up.Set(0)
 	defer c.up.Collect(ch)
 
-	var err error
+	start := time.Now()
 
-	c.client, err = hitron.New(c.config.Host, c.config.Username, c.config.Password)
+	_, err := c.session.login(c.ctx)
 	if err != nil {
-		slog.ErrorContext(c.ctx, "Error creating client", "err", err)
+		slog.ErrorContext(c.ctx, "Error logging in", "target", c.target.Host, "err", err)
 		exporterClientErrors.Inc()
+		c.state.recordScrape(start, map[string]string{"login": err.Error()}, false)
 
 		return
 	}
 
-	err = c.client.Login(c.ctx)
-	if err != nil {
-		slog.ErrorContext(c.ctx, "Error logging in", "err", err)
-		exporterClientErrors.Inc()
+	r := newScrapeRunner(c.ctx, c.state.semaphore(c.concurrency), c.collectorDuration, c.collectorSuccess)
+
+	collectors := []struct {
+		collect func(chan<- prometheus.Metric, *scrapeRunner)
+		name    string
+	}{
+		{name: "router", collect: c.rc.collect},
+		{name: "cm", collect: c.cc.collect},
+		{name: "ofdm", collect: c.cc.collectOfdm},
+		{name: "wifi", collect: c.wc.collect},
+		{name: "log", collect: c.lc.collect},
+		{name: "docsis", collect: c.dc.collect},
+		{name: "lan", collect: c.nc.collect},
+	}
 
-		return
+	for _, sub := range collectors {
+		if c.target.collectorEnabled(sub.name) {
+			sub.collect(ch, r)
+		}
 	}
 
-	defer c.client.Logout(c.ctx)
+	r.wait()
+
+	c.resets.collect(ch, c.state)
+	c.failures = r.failures()
+	c.state.recordScrape(start, c.failures, true)
 
-	c.rc.Collect(ch)
-	c.cc.Collect(ch)
-	c.wc.Collect(ch)
+	c.collectorDuration.Collect(ch)
+	c.collectorSuccess.Collect(ch)
+
+	c.sessionAge.Set(c.session.age().Seconds())
+	c.sessionAge.Collect(ch)
 
 	// collect is deferred
 	c.up.Set(1)
+	c.reachable = true
+}
+
+// scrapeRunner runs the collectors' API calls concurrently, with a limit on how
+// many can run at once, and records each call's duration and success.
+type scrapeRunner struct {
+	ctx      context.Context
+	sem      chan struct{}
+	duration *prometheus.GaugeVec
+	success  *prometheus.GaugeVec
+	// errs holds the error from each failed call, keyed by collector
+	errs map[string]string
+	// finalizers are run after all calls have finished
+	finalizers []func()
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+}
+
+// newScrapeRunner returns a runner which limits concurrent calls with sem, so
+// that concurrent scrapes of the same target share the limit
+func newScrapeRunner(ctx context.Context, sem chan struct{}, duration, success *prometheus.GaugeVec) *scrapeRunner {
+	return &scrapeRunner{
+		ctx:      ctx,
+		sem:      sem,
+		duration: duration,
+		success:  success,
+		errs:     map[string]string{},
+	}
+}
+
+// run fn in the background, as the named collector
+func (r *scrapeRunner) run(name string, fn func() error) {
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		start := time.Now()
+
+		err := r.do(fn)
+		if err != nil {
+			slog.DebugContext(r.ctx, "Collector failed", "collector", name, "err", err)
+
+			r.mu.Lock()
+			r.errs[name] = err.Error()
+			r.mu.Unlock()
+		}
+
+		success := 0.0
+		if err == nil {
+			success = 1
+		}
+
+		r.duration.WithLabelValues(name).Set(time.Since(start).Seconds())
+		r.success.WithLabelValues(name).Set(success)
+	}()
+}
+
+// do calls fn as soon as there's room to, unless the context is done first
+func (r *scrapeRunner) do(fn func() error) error {
+	select {
+	case r.sem <- struct{}{}:
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+
+	defer func() { <-r.sem }()
+
+	return fn()
+}
+
+// finally registers fn to be called once all calls have finished
+func (r *scrapeRunner) finally(fn func()) {
+	r.finalizers = append(r.finalizers, fn)
+}
+
+// wait for all calls to finish, then call the finalizers
+func (r *scrapeRunner) wait() {
+	r.wg.Wait()
+
+	for _, fn := range r.finalizers {
+		fn()
+	}
+}
+
+// failures returns the errors from the failed calls, keyed by collector. It
+// must only be called after wait.
+func (r *scrapeRunner) failures() map[string]string {
+	return r.errs
 }
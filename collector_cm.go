@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
+	"maps"
 	"strconv"
 
 	hitron "github.com/hairyhenderson/hitron_coda"
@@ -13,7 +13,7 @@ import (
 // cmCollector tracks interesting metrics from the hitron CM* APIs
 type cmCollector struct {
 	ctx     context.Context
-	client  func() *hitron.CableModem
+	session *session
 	sysInfo struct {
 		usDataRate       prometheus.Gauge
 		dsDataRate       prometheus.Gauge
@@ -43,12 +43,41 @@ type cmCollector struct {
 		repPower    *prometheus.GaugeVec
 		targetPower *prometheus.GaugeVec
 	}
+	health struct {
+		channel *prometheus.GaugeVec
+		signal  prometheus.Gauge
+	}
+	channels struct {
+		bonded  *prometheus.GaugeVec
+		locked  *prometheus.GaugeVec
+		changes prometheus.Counter
+	}
 	versionInfo *prometheus.GaugeVec
+
+	thresholds thresholdTable
+	state      *targetState
+}
+
+// channelMissingScrapes is how many scrapes a channel can be missing for
+// before it's forgotten, so that channels which are gone for good (for example
+// after the cable operator re-plans its channels) aren't reported forever
+const channelMissingScrapes = 20
+
+// channelState tracks the set of bonded channels, so that channels which drop
+// out can still be reported
+type channelState struct {
+	// known holds the channels seen recently, by direction, along with the
+	// number of scrapes since each was last seen
+	known map[string]map[string]int
+	// current holds the channels seen in the last scrape, by direction
+	current map[string]map[string]bool
+	changes float64
 }
 
 //nolint:funlen
-func newCMCollector(ctx context.Context, clientProvider func() *hitron.CableModem) cmCollector {
-	c := cmCollector{ctx: ctx, client: clientProvider}
+func newCMCollector(ctx context.Context, s *session, thresholds thresholdTable, state *targetState) cmCollector {
+	c := cmCollector{ctx: ctx, session: s, state: state}
+	c.thresholds = defaultSignalThresholds.merge(thresholds)
 
 	sub := "cm"
 
@@ -174,6 +203,38 @@ func newCMCollector(ctx context.Context, clientProvider func() *hitron.CableMode
 		Help:      "Target power (P1.6r_n, or power spectral density in 1.6MHz) of this channel, in quarter-dB above/below 1mV (quarter-dBmV).",
 	}, usOfdmLabels)
 
+	c.health.channel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "channel_health",
+		Help:      "Health of each channel's signal levels, checked against thresholds for the channel's modulation: ok (0), warn (1), or critical (2)",
+	}, []string{"direction", "channel", "check"})
+	c.health.signal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "signal_health",
+		Help:      "Overall health of the signal, the worst of all channels' health: ok (0), warn (1), or critical (2)",
+	})
+
+	c.channels.bonded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "bonded_channels",
+		Help:      "Number of bonded channels",
+	}, []string{"direction"})
+	c.channels.locked = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "channel_locked",
+		Help:      "Whether each channel is locked (1), or was previously seen but is no longer locked (0)",
+	}, []string{"direction", "channel"})
+	c.channels.changes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "channel_set_changes_total",
+		Help:      "Number of times the set of bonded channels has changed",
+	})
+
 	c.versionInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: metricsNS,
 		Subsystem: sub,
@@ -210,34 +271,219 @@ func (c cmCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.usOfdm.repPower.Describe(ch)
 	c.usOfdm.targetPower.Describe(ch)
 
+	c.health.channel.Describe(ch)
+	c.health.signal.Describe(ch)
+
+	c.channels.bonded.Describe(ch)
+	c.channels.locked.Describe(ch)
+	c.channels.changes.Describe(ch)
+
 	c.versionInfo.Describe(ch)
 }
 
-// Collect implements Prometheus.Collector.
-func (c cmCollector) Collect(ch chan<- prometheus.Metric) {
-	client := c.client()
-	if client == nil {
-		err := fmt.Errorf("client not initialized: %v", client)
-		slog.ErrorContext(c.ctx, "Error scraping target", slog.Any("err", err))
-		exporterClientErrors.Inc()
+// collect schedules the CM API calls on the runner
+func (c cmCollector) collect(ch chan<- prometheus.Metric, r *scrapeRunner) {
+	var (
+		dsinfo hitron.CMDsInfo
+		usinfo hitron.CMUsInfo
+	)
 
+	dsErr := errNotCollected
+	usErr := errNotCollected
+
+	r.run("cm_sysinfo", func() error { return c.collectSysInfo(ch) })
+	r.run("cm_dsinfo", func() error {
+		dsinfo, dsErr = c.collectDsInfo(ch)
+
+		return dsErr
+	})
+	r.run("cm_usinfo", func() error {
+		usinfo, usErr = c.collectUsInfo(ch)
+
+		return usErr
+	})
+	r.run("cm_version", func() error { return c.collectVersionInfo(ch) })
+
+	// the health and channel metrics cover both directions
+	r.finally(func() {
+		c.collectHealth(ch, dsinfo, dsErr, usinfo, usErr)
+		c.collectChannels(ch, dsinfo, dsErr, usinfo, usErr)
+		c.recordChannels(dsinfo, dsErr, usinfo, usErr)
+	})
+}
+
+// recordChannels keeps the channels, along with their signal health, for the
+// status page
+func (c cmCollector) recordChannels(
+	dsinfo hitron.CMDsInfo, dsErr error,
+	usinfo hitron.CMUsInfo, usErr error,
+) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	if dsErr == nil {
+		c.state.status.Downstream = c.channelStatuses(directionDownstream, dsinfo.Ports)
+	}
+
+	if usErr == nil {
+		c.state.status.Upstream = c.channelStatuses(directionUpstream, usinfo.Ports)
+	}
+}
+
+func (c cmCollector) channelStatuses(direction string, ports []hitron.PortInfo) []channelStatus {
+	out := make([]channelStatus, 0, len(ports))
+
+	for _, port := range ports {
+		checks := c.thresholds.evaluate(direction, []hitron.PortInfo{port})
+
+		out = append(out, channelStatus{
+			PortInfo: port,
+			Health:   worstHealth(checks),
+			Checked:  len(checks) > 0,
+		})
+	}
+
+	return out
+}
+
+// collectChannels compares the bonded channels with those seen in previous
+// scrapes
+func (c cmCollector) collectChannels(ch chan<- prometheus.Metric,
+	dsinfo hitron.CMDsInfo, dsErr error,
+	usinfo hitron.CMUsInfo, usErr error,
+) {
+	if dsErr != nil && usErr != nil {
 		return
 	}
 
-	c.collectSysInfo(ch, client)
-	c.collectDsInfo(ch, client)
-	c.collectUsInfo(ch, client)
-	c.collectOfdm(ch, client)
-	c.collectVersionInfo(ch, client)
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	st := &c.state.channels
+
+	dirs := []struct {
+		err       error
+		direction string
+		ports     []hitron.PortInfo
+	}{
+		{direction: directionDownstream, ports: dsinfo.Ports, err: dsErr},
+		{direction: directionUpstream, ports: usinfo.Ports, err: usErr},
+	}
+
+	for _, d := range dirs {
+		if d.err != nil {
+			continue
+		}
+
+		channels := make([]string, 0, len(d.ports))
+		for _, port := range d.ports {
+			channels = append(channels, port.ChannelID)
+		}
+
+		st.update(d.direction, channels)
+
+		c.channels.bonded.WithLabelValues(d.direction).Set(float64(len(st.current[d.direction])))
+
+		for channel := range st.known[d.direction] {
+			c.channels.locked.WithLabelValues(d.direction, channel).Set(boolFloat(st.current[d.direction][channel]))
+		}
+	}
+
+	c.channels.changes.Add(st.changes)
+
+	c.channels.bonded.Collect(ch)
+	c.channels.locked.Collect(ch)
+	c.channels.changes.Collect(ch)
+}
+
+// update records the channels currently bonded in the given direction,
+// counting a change if they differ from the last update. Channels missing for
+// more than channelMissingScrapes updates are forgotten.
+func (s *channelState) update(direction string, channels []string) {
+	if s.known == nil {
+		s.known = map[string]map[string]int{}
+		s.current = map[string]map[string]bool{}
+	}
+
+	current := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		current[channel] = true
+	}
+
+	prev, ok := s.current[direction]
+	if ok && !maps.Equal(prev, current) {
+		s.changes++
+	}
+
+	s.current[direction] = current
+
+	known := s.known[direction]
+	if known == nil {
+		known = map[string]int{}
+		s.known[direction] = known
+	}
+
+	for channel := range known {
+		known[channel]++
+
+		if known[channel] > channelMissingScrapes {
+			delete(known, channel)
+		}
+	}
+
+	for channel := range current {
+		known[channel] = 0
+	}
 }
 
-func (c cmCollector) collectVersionInfo(ch chan<- prometheus.Metric, client *hitron.CableModem) {
-	vi, err := client.CMVersion(c.ctx)
+// collectHealth checks the signal levels of each channel against the
+// thresholds. The overall signal health is only known when both directions
+// were collected.
+func (c cmCollector) collectHealth(ch chan<- prometheus.Metric,
+	dsinfo hitron.CMDsInfo, dsErr error,
+	usinfo hitron.CMUsInfo, usErr error,
+) {
+	checks := map[string][]channelCheck{}
+
+	if dsErr == nil {
+		checks[directionDownstream] = c.thresholds.evaluate(directionDownstream, dsinfo.Ports)
+	}
+
+	if usErr == nil {
+		checks[directionUpstream] = c.thresholds.evaluate(directionUpstream, usinfo.Ports)
+	}
+
+	worst := healthOK
+
+	for direction, dirChecks := range checks {
+		for _, check := range dirChecks {
+			c.health.channel.WithLabelValues(direction, check.channel, check.check).Set(float64(check.state))
+		}
+
+		worst = max(worst, worstHealth(dirChecks))
+	}
+
+	c.health.channel.Collect(ch)
+
+	if dsErr == nil && usErr == nil {
+		c.health.signal.Set(float64(worst))
+		c.health.signal.Collect(ch)
+	}
+}
+
+// collectOfdm schedules the CM OFDM API calls on the runner
+func (c cmCollector) collectOfdm(ch chan<- prometheus.Metric, r *scrapeRunner) {
+	r.run("cm_usofdm", func() error { return c.collectUsOfdm(ch) })
+	r.run("cm_dsofdm", func() error { return c.collectDsOfdm(ch) })
+}
+
+func (c cmCollector) collectVersionInfo(ch chan<- prometheus.Metric) error {
+	vi, err := call(c.ctx, c.session, (*hitron.CableModem).CMVersion)
 	if err != nil {
 		slog.ErrorContext(c.ctx, "Error scraping CMVersion", slog.Any("err", err))
 		exporterRequestErrors.Inc()
 
-		return
+		return err
 	}
 
 	l := prometheus.Labels{
@@ -251,15 +497,17 @@ func (c cmCollector) collectVersionInfo(ch chan<- prometheus.Metric, client *hit
 	}
 	c.versionInfo.With(l).Set(1)
 	c.versionInfo.Collect(ch)
+
+	return nil
 }
 
-func (c cmCollector) collectSysInfo(ch chan<- prometheus.Metric, client *hitron.CableModem) {
-	si, err := client.CMSysInfo(c.ctx)
+func (c cmCollector) collectSysInfo(ch chan<- prometheus.Metric) error {
+	si, err := call(c.ctx, c.session, (*hitron.CableModem).CMSysInfo)
 	if err != nil {
 		slog.ErrorContext(c.ctx, "Error scraping CMSysInfo", slog.Any("err", err))
 		exporterRequestErrors.Inc()
 
-		return
+		return err
 	}
 
 	// bytes not bits
@@ -276,15 +524,17 @@ func (c cmCollector) collectSysInfo(ch chan<- prometheus.Metric, client *hitron.
 		WithLabelValues(si.IP.String(), si.MacAddr.String()).
 		Set(si.Lease.Seconds())
 	c.sysInfo.dhcpLeaseSeconds.Collect(ch)
+
+	return nil
 }
 
-func (c cmCollector) collectDsInfo(ch chan<- prometheus.Metric, client *hitron.CableModem) {
-	dsinfo, err := client.CMDsInfo(c.ctx)
+func (c cmCollector) collectDsInfo(ch chan<- prometheus.Metric) (hitron.CMDsInfo, error) {
+	dsinfo, err := call(c.ctx, c.session, (*hitron.CableModem).CMDsInfo)
 	if err != nil {
 		slog.ErrorContext(c.ctx, "Error scraping CMDsInfo", slog.Any("err", err))
 		exporterRequestErrors.Inc()
 
-		return
+		return dsinfo, err
 	}
 
 	for _, port := range dsinfo.Ports {
@@ -300,6 +550,11 @@ func (c cmCollector) collectDsInfo(ch chan<- prometheus.Metric, client *hitron.C
 		c.dsInfo.receivedBytes.With(l).Add(float64(port.DsOctets))
 		c.dsInfo.corrected.With(l).Add(float64(port.Correcteds))
 		c.dsInfo.uncorrected.With(l).Add(float64(port.Uncorrect))
+
+		series := []string{port.PortID, port.ChannelID}
+		c.state.observeCounter("hitron_coda_cm_downstream_received_bytes", series, float64(port.DsOctets))
+		c.state.observeCounter("hitron_coda_cm_downstream_corrected_blocks", series, float64(port.Correcteds))
+		c.state.observeCounter("hitron_coda_cm_downstream_uncorrected_blocks", series, float64(port.Uncorrect))
 	}
 
 	c.dsInfo.frequency.Collect(ch)
@@ -308,15 +563,17 @@ func (c cmCollector) collectDsInfo(ch chan<- prometheus.Metric, client *hitron.C
 	c.dsInfo.receivedBytes.Collect(ch)
 	c.dsInfo.corrected.Collect(ch)
 	c.dsInfo.uncorrected.Collect(ch)
+
+	return dsinfo, nil
 }
 
-func (c cmCollector) collectUsInfo(ch chan<- prometheus.Metric, client *hitron.CableModem) {
-	usinfo, err := client.CMUsInfo(c.ctx)
+func (c cmCollector) collectUsInfo(ch chan<- prometheus.Metric) (hitron.CMUsInfo, error) {
+	usinfo, err := call(c.ctx, c.session, (*hitron.CableModem).CMUsInfo)
 	if err != nil {
 		slog.ErrorContext(c.ctx, "Error scraping CMUsInfo", slog.Any("err", err))
 		exporterRequestErrors.Inc()
 
-		return
+		return usinfo, err
 	}
 
 	for _, port := range usinfo.Ports {
@@ -336,51 +593,71 @@ func (c cmCollector) collectUsInfo(ch chan<- prometheus.Metric, client *hitron.C
 	c.usInfo.frequency.Collect(ch)
 	c.usInfo.signalStrength.Collect(ch)
 	c.usInfo.bandwidth.Collect(ch)
+
+	return usinfo, nil
 }
 
-func (c cmCollector) collectOfdm(ch chan<- prometheus.Metric, client *hitron.CableModem) {
-	usofdm, err := client.CMUsOfdm(c.ctx)
+func (c cmCollector) collectUsOfdm(ch chan<- prometheus.Metric) error {
+	usofdm, err := call(c.ctx, c.session, (*hitron.CableModem).CMUsOfdm)
 	if err != nil {
 		slog.ErrorContext(c.ctx, "Error scraping CMUsOfdm", slog.Any("err", err))
 		exporterRequestErrors.Inc()
-	} else {
-		for _, channel := range usofdm.Channels {
-			l := prometheus.Labels{
-				"channel":  strconv.Itoa(channel.ID),
-				"enabled":  strconv.FormatBool(channel.Enable),
-				"fft_size": channel.FFTSize,
-			}
-
-			c.usOfdm.channelBw.With(l).Set(channel.ChannelBw)
-			c.usOfdm.digAtten.With(l).Set(channel.DigAtten)
-			c.usOfdm.digAttenBo.With(l).Set(channel.DigAttenBo)
-			c.usOfdm.repPower.With(l).Set(channel.RepPower)
-			c.usOfdm.targetPower.With(l).Set(channel.RepPower1_6)
+
+		return err
+	}
+
+	for _, channel := range usofdm.Channels {
+		l := prometheus.Labels{
+			"channel":  strconv.Itoa(channel.ID),
+			"enabled":  strconv.FormatBool(channel.Enable),
+			"fft_size": channel.FFTSize,
 		}
 
-		c.usOfdm.channelBw.Collect(ch)
-		c.usOfdm.digAtten.Collect(ch)
-		c.usOfdm.digAttenBo.Collect(ch)
-		c.usOfdm.repPower.Collect(ch)
-		c.usOfdm.targetPower.Collect(ch)
+		c.usOfdm.channelBw.With(l).Set(channel.ChannelBw)
+		c.usOfdm.digAtten.With(l).Set(channel.DigAtten)
+		c.usOfdm.digAttenBo.With(l).Set(channel.DigAttenBo)
+		c.usOfdm.repPower.With(l).Set(channel.RepPower)
+		c.usOfdm.targetPower.With(l).Set(channel.RepPower1_6)
 	}
 
-	dsofdm, err := client.CMDsOfdm(c.ctx)
+	c.usOfdm.channelBw.Collect(ch)
+	c.usOfdm.digAtten.Collect(ch)
+	c.usOfdm.digAttenBo.Collect(ch)
+	c.usOfdm.repPower.Collect(ch)
+	c.usOfdm.targetPower.Collect(ch)
+
+	c.state.mu.Lock()
+	c.state.status.UsOfdm = usofdm.Channels
+	c.state.mu.Unlock()
+
+	return nil
+}
+
+func (c cmCollector) collectDsOfdm(ch chan<- prometheus.Metric) error {
+	dsofdm, err := call(c.ctx, c.session, (*hitron.CableModem).CMDsOfdm)
 	if err != nil {
 		slog.ErrorContext(c.ctx, "Error scraping CMDsOfdm", slog.Any("err", err))
 		exporterRequestErrors.Inc()
-	} else {
-		for _, receiver := range dsofdm.Receivers {
-			l := prometheus.Labels{
-				"receiver": strconv.Itoa(receiver.ID),
-				"fft_type": receiver.FFTType,
-			}
-
-			c.dsOfdm.plcPower.With(l).Set(receiver.PLCPower)
-			c.dsOfdm.subcarrierFreq.With(l).Set(float64(receiver.SubcarrierFreq))
+
+		return err
+	}
+
+	for _, receiver := range dsofdm.Receivers {
+		l := prometheus.Labels{
+			"receiver": strconv.Itoa(receiver.ID),
+			"fft_type": receiver.FFTType,
 		}
 
-		c.dsOfdm.plcPower.Collect(ch)
-		c.dsOfdm.subcarrierFreq.Collect(ch)
+		c.dsOfdm.plcPower.With(l).Set(receiver.PLCPower)
+		c.dsOfdm.subcarrierFreq.With(l).Set(float64(receiver.SubcarrierFreq))
 	}
+
+	c.dsOfdm.plcPower.Collect(ch)
+	c.dsOfdm.subcarrierFreq.Collect(ch)
+
+	c.state.mu.Lock()
+	c.state.status.DsOfdm = dsofdm.Receivers
+	c.state.mu.Unlock()
+
+	return nil
 }
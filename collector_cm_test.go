@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelStateUpdate(t *testing.T) {
+	s := &channelState{}
+
+	s.update(directionDownstream, []string{"9", "10", "11"})
+	s.update(directionUpstream, []string{"3", "4"})
+	assert.Zero(t, s.changes)
+
+	// the same channels, in a different order
+	s.update(directionDownstream, []string{"11", "10", "9"})
+	assert.Zero(t, s.changes)
+
+	// a channel drops out
+	s.update(directionDownstream, []string{"9", "11"})
+	assert.Equal(t, 1.0, s.changes)
+	assert.Equal(t, map[string]bool{"9": true, "11": true}, s.current[directionDownstream])
+	assert.Equal(t, map[string]int{"9": 0, "10": 1, "11": 0}, s.known[directionDownstream])
+
+	// and is replaced
+	s.update(directionDownstream, []string{"9", "11", "12"})
+	assert.Equal(t, 2.0, s.changes)
+	assert.Len(t, s.known[directionDownstream], 4)
+	assert.Len(t, s.known[directionUpstream], 2)
+
+	// channels which stay gone are eventually forgotten
+	for range channelMissingScrapes - 2 {
+		s.update(directionDownstream, []string{"9", "11", "12"})
+	}
+
+	assert.Contains(t, s.known[directionDownstream], "10")
+
+	s.update(directionDownstream, []string{"9", "11", "12"})
+	assert.Equal(t, map[string]int{"9": 0, "11": 0, "12": 0}, s.known[directionDownstream])
+	assert.Len(t, s.known[directionUpstream], 2)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	hitron "github.com/hairyhenderson/hitron_coda"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// docsisCollector tracks the cable modem's DOCSIS provisioning and
+// registration status
+type docsisCollector struct {
+	ctx     context.Context
+	session *session
+
+	info          *prometheus.GaugeVec
+	stepSuccess   *prometheus.GaugeVec
+	networkAccess prometheus.Gauge
+	bpiEnabled    prometheus.Gauge
+}
+
+func newDocsisCollector(ctx context.Context, s *session) docsisCollector {
+	c := docsisCollector{ctx: ctx, session: s}
+
+	sub := "cm"
+
+	c.info = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "provisioning_info",
+		Help:      "A metric with a constant '1' value labeled by the cable modem's DOCSIS provisioning status.",
+	}, []string{"docsis_version", "config_file", "network_access", "bpi_status", "eae_status", "tod_status"})
+	c.stepSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "provisioning_step_success",
+		Help:      "Whether each step of the cable modem's DOCSIS provisioning succeeded (1), or not (0)",
+	}, []string{"step"})
+	c.networkAccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "network_access_allowed",
+		Help:      "Whether the cable modem is registered and allowed network access by the CMTS (1), or not (0)",
+	})
+	c.bpiEnabled = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "bpi_enabled",
+		Help:      "Whether Baseline Privacy Interface (BPI) encryption is enabled (1), or not (0)",
+	})
+
+	return c
+}
+
+// Describe implements Prometheus.Collector.
+func (c docsisCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.info.Describe(ch)
+	c.stepSuccess.Describe(ch)
+	c.networkAccess.Describe(ch)
+	c.bpiEnabled.Describe(ch)
+}
+
+// collect schedules the DOCSIS provisioning API call on the runner
+func (c docsisCollector) collect(ch chan<- prometheus.Metric, r *scrapeRunner) {
+	r.run("cm_docsis", func() error { return c.collectProvisioning(ch) })
+}
+
+func (c docsisCollector) collectProvisioning(ch chan<- prometheus.Metric) error {
+	p, err := call(c.ctx, c.session, (*hitron.CableModem).CMDocsisProvision)
+	if err != nil {
+		slog.ErrorContext(c.ctx, "Error scraping CMDocsisProvision", "err", err)
+		exporterRequestErrors.Inc()
+
+		return err
+	}
+
+	c.info.With(prometheus.Labels{
+		"docsis_version": p.DocsisVersion,
+		"config_file":    p.ConfigFile,
+		"network_access": p.NetworkAccess,
+		"bpi_status":     p.BPIStatus,
+		"eae_status":     p.EAEStatus,
+		"tod_status":     p.TimeOfDay,
+	}).Set(1)
+	c.info.Collect(ch)
+
+	steps := []struct {
+		name, status string
+	}{
+		{"hw_init", p.HWInit},
+		{"find_downstream", p.FindDownstream},
+		{"ranging", p.Ranging},
+		{"dhcp", p.DHCP},
+		{"time_of_day", p.TimeOfDay},
+		{"config_download", p.DownloadCMConfig},
+		{"registration", p.Registration},
+	}
+
+	for _, step := range steps {
+		c.stepSuccess.WithLabelValues(step.name).Set(boolFloat(provisioningStepSucceeded(step.status)))
+	}
+
+	c.stepSuccess.Collect(ch)
+
+	c.networkAccess.Set(boolFloat(networkAccessAllowed(p.NetworkAccess)))
+	c.networkAccess.Collect(ch)
+
+	c.bpiEnabled.Set(boolFloat(strings.EqualFold(strings.TrimSpace(p.BPIStatus), "enabled")))
+	c.bpiEnabled.Collect(ch)
+
+	return nil
+}
+
+// provisioningStepSucceeded interprets the status of a provisioning step - the
+// device reports "Success" for completed steps, and e.g. "Process" or
+// "Not Started" for incomplete ones
+func provisioningStepSucceeded(status string) bool {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "success", "done", "completed", "ok":
+		return true
+	default:
+		return false
+	}
+}
+
+// networkAccessAllowed interprets the network access status, which the device
+// reports as "Permitted" or "Denied"
+func networkAccessAllowed(status string) bool {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "permitted", "allowed", "enabled", "true", "1":
+		return true
+	default:
+		return false
+	}
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
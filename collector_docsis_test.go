@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvisioningStepSucceeded(t *testing.T) {
+	assert.True(t, provisioningStepSucceeded("Success"))
+	assert.True(t, provisioningStepSucceeded(" success "))
+	assert.False(t, provisioningStepSucceeded("Process"))
+	assert.False(t, provisioningStepSucceeded("Not Started"))
+	assert.False(t, provisioningStepSucceeded(""))
+}
+
+func TestNetworkAccessAllowed(t *testing.T) {
+	assert.True(t, networkAccessAllowed("Permitted"))
+	assert.False(t, networkAccessAllowed("Denied"))
+	assert.False(t, networkAccessAllowed(""))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	hitron "github.com/hairyhenderson/hitron_coda"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lanCollector tracks the hosts connected to the router's LAN, wired and
+// wireless
+type lanCollector struct {
+	ctx     context.Context
+	session *session
+
+	info        *prometheus.GaugeVec
+	hosts       *prometheus.GaugeVec
+	leaseExpiry *prometheus.GaugeVec
+
+	// hashKey is used to hash MAC addresses and hostnames, when set
+	hashKey []byte
+}
+
+func newLANCollector(ctx context.Context, s *session, conf lanHostsConfig, hashKey []byte) lanCollector {
+	c := lanCollector{ctx: ctx, session: s}
+
+	if conf.HashIdentifiers {
+		c.hashKey = hashKey
+	}
+
+	sub := "router"
+
+	c.info = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "lan_host_info",
+		Help:      "A metric with a constant '1' value labeled by information about each host connected to the LAN.",
+	}, []string{"hostname", "mac_addr", "ip", "interface", "address_source"})
+	c.hosts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "lan_hosts",
+		Help:      "Number of hosts connected to the LAN, by interface",
+	}, []string{"interface"})
+	c.leaseExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "lan_host_lease_expiry_timestamp_seconds",
+		Help:      "Time when each host's DHCP lease expires, in seconds since the epoch",
+	}, []string{"mac_addr", "ip"})
+
+	return c
+}
+
+// Describe implements Prometheus.Collector.
+func (c lanCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.info.Describe(ch)
+	c.hosts.Describe(ch)
+	c.leaseExpiry.Describe(ch)
+}
+
+// collect schedules the connected devices API call on the runner
+func (c lanCollector) collect(ch chan<- prometheus.Metric, r *scrapeRunner) {
+	r.run("router_lan_hosts", func() error { return c.collectHosts(ch) })
+}
+
+func (c lanCollector) collectHosts(ch chan<- prometheus.Metric) error {
+	devices, err := call(c.ctx, c.session, (*hitron.CableModem).RouterConnectedDevices)
+	if err != nil {
+		slog.ErrorContext(c.ctx, "Error scraping RouterConnectedDevices", "err", err)
+		exporterRequestErrors.Inc()
+
+		return err
+	}
+
+	// hosts with both IPv4 and IPv6 addresses are listed more than once
+	hosts := map[string]map[string]bool{}
+
+	for _, d := range devices.Devices {
+		mac := c.identifier(d.MACAddr.String())
+
+		c.info.With(prometheus.Labels{
+			"hostname":       c.identifier(d.Hostname),
+			"mac_addr":       mac,
+			"ip":             d.IP.String(),
+			"interface":      d.Interface,
+			"address_source": d.AddressSource,
+		}).Set(1)
+
+		if !d.LeaseEnd.IsZero() {
+			c.leaseExpiry.WithLabelValues(mac, d.IP.String()).Set(float64(d.LeaseEnd.Unix()))
+		}
+
+		if hosts[d.Interface] == nil {
+			hosts[d.Interface] = map[string]bool{}
+		}
+
+		hosts[d.Interface][mac] = true
+	}
+
+	for iface, macs := range hosts {
+		c.hosts.WithLabelValues(iface).Set(float64(len(macs)))
+	}
+
+	c.info.Collect(ch)
+	c.hosts.Collect(ch)
+	c.leaseExpiry.Collect(ch)
+
+	return nil
+}
+
+// identifier returns the identifier, hashed if hashing is enabled
+func (c lanCollector) identifier(id string) string {
+	if c.hashKey == nil {
+		return id
+	}
+
+	return hashIdentifier(c.hashKey, id)
+}
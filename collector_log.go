@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	hitron "github.com/hairyhenderson/hitron_coda"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// logCollector tracks the cable modem's DOCSIS event log. Entries are counted
+// and logged once each, no matter how many scrapes they're seen in.
+type logCollector struct {
+	ctx     context.Context
+	session *session
+	state   *targetState
+
+	events    *prometheus.CounterVec
+	lastEvent prometheus.Gauge
+}
+
+// logState tracks which event log entries have been seen already
+type logState struct {
+	// seen holds the number of entries with each key in the last log fetched -
+	// entries eventually drop out of the device's log, so older keys aren't
+	// kept. Identical events can be logged in the same second, so the entries
+	// with each key are counted, rather than just noted.
+	seen   map[string]int
+	counts map[[2]string]float64
+	last   time.Time
+}
+
+func newLogCollector(ctx context.Context, s *session, state *targetState) logCollector {
+	c := logCollector{ctx: ctx, session: s, state: state}
+
+	sub := "cm"
+
+	c.events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "log_events_total",
+		Help:      "Number of entries seen in the cable modem's event log",
+	}, []string{"priority", "event_id"})
+	c.lastEvent = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "log_last_event_timestamp_seconds",
+		Help:      "Time of the most recent entry in the cable modem's event log, in seconds since the epoch",
+	})
+
+	return c
+}
+
+// Describe implements Prometheus.Collector.
+func (c logCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.events.Describe(ch)
+	c.lastEvent.Describe(ch)
+}
+
+// collect schedules the event log API call on the runner
+func (c logCollector) collect(ch chan<- prometheus.Metric, r *scrapeRunner) {
+	r.run("cm_log", func() error { return c.collectLog(ch) })
+}
+
+func (c logCollector) collectLog(ch chan<- prometheus.Metric) error {
+	cmlog, err := call(c.ctx, c.session, (*hitron.CableModem).CMLog)
+	if err != nil {
+		slog.ErrorContext(c.ctx, "Error scraping CMLog", "err", err)
+		exporterRequestErrors.Inc()
+
+		return err
+	}
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	first := c.state.log.seen == nil
+
+	for _, e := range c.state.log.update(cmlog.Entries) {
+		// entries already in the log at startup were most likely logged by a
+		// previous instance of the exporter
+		level := slog.LevelDebug
+		if !first {
+			level = logEventLevel(logPriority(e.Priority))
+		}
+
+		slog.Log(c.ctx, level, "Device event",
+			"target", c.session.host,
+			"time", e.Time,
+			"priority", logPriority(e.Priority),
+			"event_id", e.EventID,
+			"message", e.Message)
+	}
+
+	for k, v := range c.state.log.counts {
+		c.events.WithLabelValues(k[0], k[1]).Add(v)
+	}
+
+	c.events.Collect(ch)
+
+	if !c.state.log.last.IsZero() {
+		c.lastEvent.Set(float64(c.state.log.last.Unix()))
+		c.lastEvent.Collect(ch)
+	}
+
+	return nil
+}
+
+// update counts the entries not seen before, and returns them
+func (s *logState) update(entries []hitron.CMLogEntry) []hitron.CMLogEntry {
+	if s.counts == nil {
+		s.counts = map[[2]string]float64{}
+	}
+
+	seen := make(map[string]int, len(entries))
+	newEntries := []hitron.CMLogEntry{}
+
+	for _, e := range entries {
+		// the index isn't used, as it changes when older entries are dropped
+		key := strconv.FormatInt(e.Time.Unix(), 10) + "\x00" + e.EventID + "\x00" + e.Message
+
+		seen[key]++
+
+		// only the entries beyond those already seen with this key are new
+		if seen[key] <= s.seen[key] {
+			continue
+		}
+
+		newEntries = append(newEntries, e)
+		s.counts[[2]string{logPriority(e.Priority), e.EventID}]++
+
+		if e.Time.After(s.last) {
+			s.last = e.Time
+		}
+	}
+
+	s.seen = seen
+
+	return newEntries
+}
+
+// logPriority normalises the device's priority names (e.g. "critical(3)" to
+// "critical")
+func logPriority(p string) string {
+	p, _, _ = strings.Cut(p, "(")
+
+	return strings.ToLower(strings.TrimSpace(p))
+}
+
+// logEventLevel maps a syslog priority to a log level
+func logEventLevel(priority string) slog.Level {
+	switch priority {
+	case "emergency", "alert", "critical", "error":
+		return slog.LevelError
+	case "warning":
+		return slog.LevelWarn
+	case "debug":
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	hitron "github.com/hairyhenderson/hitron_coda"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogStateUpdate(t *testing.T) {
+	t0 := time.Date(2026, 10, 12, 3, 14, 7, 0, time.UTC)
+
+	t3 := hitron.CMLogEntry{ID: 1, Time: t0, Priority: "critical(3)", EventID: "82000200", Message: "T3 time-out"}
+	mdd := hitron.CMLogEntry{ID: 2, Time: t0.Add(time.Minute), Priority: "warning(5)", EventID: "84020200", Message: "Lost MDD Timeout"}
+
+	s := &logState{}
+
+	// identical events in the same second are each counted
+	assert.Equal(t, []hitron.CMLogEntry{t3, mdd, t3}, s.update([]hitron.CMLogEntry{t3, mdd, t3}))
+	assert.Equal(t, map[[2]string]float64{
+		{"critical", "82000200"}: 2,
+		{"warning", "84020200"}:  1,
+	}, s.counts)
+	assert.Equal(t, t0.Add(time.Minute), s.last)
+
+	// but aren't counted again when they're seen again, only the extra one is
+	assert.Equal(t, []hitron.CMLogEntry{t3}, s.update([]hitron.CMLogEntry{t3, t3, mdd, t3}))
+	assert.Equal(t, map[[2]string]float64{
+		{"critical", "82000200"}: 3,
+		{"warning", "84020200"}:  1,
+	}, s.counts)
+
+	// entries already seen aren't counted again, even when their index changes
+	t3Again := hitron.CMLogEntry{ID: 3, Time: t0.Add(time.Hour), Priority: "critical(3)", EventID: "82000200", Message: "T3 time-out"}
+	mdd.ID = 1
+
+	assert.Equal(t, []hitron.CMLogEntry{t3Again}, s.update([]hitron.CMLogEntry{mdd, t3Again}))
+	assert.Equal(t, map[[2]string]float64{
+		{"critical", "82000200"}: 4,
+		{"warning", "84020200"}:  1,
+	}, s.counts)
+	assert.Equal(t, t0.Add(time.Hour), s.last)
+	assert.Len(t, s.seen, 2)
+}
+
+func TestLogPriority(t *testing.T) {
+	assert.Equal(t, "critical", logPriority("critical(3)"))
+	assert.Equal(t, "notice", logPriority(" Notice (6)"))
+	assert.Equal(t, "warning", logPriority("warning"))
+
+	assert.Equal(t, slog.LevelError, logEventLevel("critical"))
+	assert.Equal(t, slog.LevelWarn, logEventLevel("warning"))
+	assert.Equal(t, slog.LevelInfo, logEventLevel("notice"))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
 
 	hitron "github.com/hairyhenderson/hitron_coda"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,8 +13,11 @@ import (
 // routerCollector tracks interesting metrics from the hitron Router* APIs
 type routerCollector struct {
 	ctx     context.Context
-	client  func() *hitron.CableModem
-	sysInfo struct {
+	session *session
+	state   *targetState
+	// location overrides the location configured on the device, if set
+	location string
+	sysInfo  struct {
 		systemTimeSeconds       prometheus.Gauge
 		lanReceiveBytesTotal    *prometheus.CounterVec
 		lanTransmitBytesTotal   *prometheus.CounterVec
@@ -28,8 +32,8 @@ type routerCollector struct {
 }
 
 //nolint:funlen
-func newRouterCollector(ctx context.Context, clientProvider func() *hitron.CableModem) routerCollector {
-	c := routerCollector{ctx: ctx, client: clientProvider}
+func newRouterCollector(ctx context.Context, s *session, location string, state *targetState) routerCollector {
+	c := routerCollector{ctx: ctx, session: s, location: location, state: state}
 
 	sub := "router"
 
@@ -105,57 +109,108 @@ func (c routerCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.sysInfo.lanReceiveBytesTotal.Describe(ch)
 }
 
-// Collect implements Prometheus.Collector.
-func (c routerCollector) Collect(ch chan<- prometheus.Metric) {
-	client := c.client()
-	if client == nil {
-		err := fmt.Errorf("client not initialized: %v", client)
-		slog.ErrorContext(c.ctx, "Error scraping target", "err", err)
-		exporterClientErrors.Inc()
+// collect schedules the router API calls on the runner
+func (c routerCollector) collect(ch chan<- prometheus.Metric, r *scrapeRunner) {
+	var (
+		si  hitron.RouterSysInfo
+		loc hitron.RouterLocation
+	)
 
-		return
-	}
+	siErr := errNotCollected
+	locErr := errNotCollected
+
+	r.run("router_sysinfo", func() error {
+		si, siErr = c.collectSysInfo(ch)
+
+		return siErr
+	})
+
+	r.run("router_location", func() error {
+		loc, locErr = call(c.ctx, c.session, (*hitron.CableModem).RouterLocation)
+		if locErr != nil {
+			slog.ErrorContext(c.ctx, "Error scraping RouterLocation", "err", locErr)
+			exporterRequestErrors.Inc()
+		}
+
+		return locErr
+	})
 
-	si, err := client.RouterSysInfo(c.ctx)
+	// the info metric needs both the sysinfo and the location
+	r.finally(func() {
+		if siErr != nil || locErr != nil {
+			return
+		}
+
+		if c.location != "" {
+			loc.LocationText = c.location
+		}
+
+		c.sysInfo.info.With(routerSysInfoLabels(si, loc)).Set(1)
+		c.sysInfo.info.Collect(ch)
+	})
+}
+
+func (c routerCollector) collectSysInfo(ch chan<- prometheus.Metric) (hitron.RouterSysInfo, error) {
+	si, err := call(c.ctx, c.session, (*hitron.CableModem).RouterSysInfo)
 	if err != nil {
 		slog.ErrorContext(c.ctx, "Error scraping RouterSysInfo", "err", err)
 		exporterRequestErrors.Inc()
-	} else {
-		c.sysInfo.systemTimeSeconds.Set(float64(si.SystemTime.Unix()))
-		c.sysInfo.systemTimeSeconds.Collect(ch)
 
-		c.sysInfo.lanReceiveBytesTotal.WithLabelValues(si.LANName).Add(float64(si.LanRx))
-		c.sysInfo.lanReceiveBytesTotal.Collect(ch)
+		return si, err
+	}
+
+	c.observeResets(si)
+
+	c.sysInfo.systemTimeSeconds.Set(float64(si.SystemTime.Unix()))
+	c.sysInfo.systemTimeSeconds.Collect(ch)
+
+	c.sysInfo.lanReceiveBytesTotal.WithLabelValues(si.LANName).Add(float64(si.LanRx))
+	c.sysInfo.lanReceiveBytesTotal.Collect(ch)
+
+	c.sysInfo.lanTransmitBytesTotal.WithLabelValues(si.LANName).Add(float64(si.LanTx))
+	c.sysInfo.lanTransmitBytesTotal.Collect(ch)
 
-		c.sysInfo.lanTransmitBytesTotal.WithLabelValues(si.LANName).Add(float64(si.LanTx))
-		c.sysInfo.lanTransmitBytesTotal.Collect(ch)
+	c.sysInfo.systemLanUptimeSeconds.WithLabelValues(si.LANName).Set(si.SystemLanUptime.Seconds())
+	c.sysInfo.systemLanUptimeSeconds.Collect(ch)
 
-		c.sysInfo.systemLanUptimeSeconds.WithLabelValues(si.LANName).Set(si.SystemLanUptime.Seconds())
-		c.sysInfo.systemLanUptimeSeconds.Collect(ch)
+	c.sysInfo.wanReceiveBytesTotal.WithLabelValues(si.WanName).Add(float64(si.WanRx))
+	c.sysInfo.wanReceiveBytesTotal.Collect(ch)
 
-		c.sysInfo.wanReceiveBytesTotal.WithLabelValues(si.WanName).Add(float64(si.WanRx))
-		c.sysInfo.wanReceiveBytesTotal.Collect(ch)
+	c.sysInfo.wanTransmitBytesTotal.WithLabelValues(si.WanName).Add(float64(si.WanTx))
+	c.sysInfo.wanTransmitBytesTotal.Collect(ch)
 
-		c.sysInfo.wanTransmitBytesTotal.WithLabelValues(si.WanName).Add(float64(si.WanTx))
-		c.sysInfo.wanTransmitBytesTotal.Collect(ch)
+	c.sysInfo.wanReceivePacketsTotal.WithLabelValues(si.WanName).Add(float64(si.WanRxPkts))
+	c.sysInfo.wanReceivePacketsTotal.Collect(ch)
 
-		c.sysInfo.wanReceivePacketsTotal.WithLabelValues(si.WanName).Add(float64(si.WanRxPkts))
-		c.sysInfo.wanReceivePacketsTotal.Collect(ch)
+	c.sysInfo.wanTransmitPacketsTotal.WithLabelValues(si.WanName).Add(float64(si.WanTxPkts))
+	c.sysInfo.wanTransmitPacketsTotal.Collect(ch)
 
-		c.sysInfo.wanTransmitPacketsTotal.WithLabelValues(si.WanName).Add(float64(si.WanTxPkts))
-		c.sysInfo.wanTransmitPacketsTotal.Collect(ch)
+	c.sysInfo.systemWanUptimeSeconds.WithLabelValues(si.WanName).Set(si.SystemWanUptime.Seconds())
+	c.sysInfo.systemWanUptimeSeconds.Collect(ch)
 
-		c.sysInfo.systemWanUptimeSeconds.WithLabelValues(si.WanName).Set(si.SystemWanUptime.Seconds())
-		c.sysInfo.systemWanUptimeSeconds.Collect(ch)
+	return si, nil
+}
+
+// observeResets records the uptimes and counters, to detect restarts and
+// counter resets
+func (c routerCollector) observeResets(si hitron.RouterSysInfo) {
+	c.state.observeUptimes(time.Now(), si.SystemLanUptime, si.SystemWanUptime)
+
+	counters := []struct {
+		metric string
+		name   string
+		v      float64
+	}{
+		{"hitron_coda_router_lan_receive_bytes_total", si.LANName, float64(si.LanRx)},
+		{"hitron_coda_router_lan_transmit_bytes_total", si.LANName, float64(si.LanTx)},
+		{"hitron_coda_router_wan_receive_bytes_total", si.WanName, float64(si.WanRx)},
+		{"hitron_coda_router_wan_transmit_bytes_total", si.WanName, float64(si.WanTx)},
+		{"hitron_coda_router_wan_receive_packets_total", si.WanName, float64(si.WanRxPkts)},
+		{"hitron_coda_router_wan_transmit_packets_total", si.WanName, float64(si.WanTxPkts)},
 	}
 
-	loc, err := client.RouterLocation(c.ctx)
-	if err != nil {
-		slog.ErrorContext(c.ctx, "Error scraping RouterLocation", "err", err)
-		exporterRequestErrors.Inc()
-	} else {
-		c.sysInfo.info.With(routerSysInfoLabels(si, loc)).Set(1)
-		c.sysInfo.info.Collect(ch)
+	for _, counter := range counters {
+		c.state.observeCounter(counter.metric, []string{counter.name}, counter.v)
 	}
 }
 
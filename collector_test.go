@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/hairyhenderson/hitron_coda_exporter/internal/fakemodem"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeRunner(t *testing.T) {
+	duration := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "duration"}, []string{"collector"})
+	success := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "success"}, []string{"collector"})
+
+	// both runners share the target's limit
+	sem := (&targetState{}).semaphore(2)
+	r := newScrapeRunner(context.Background(), sem, duration, success)
+	r2 := newScrapeRunner(context.Background(), sem, duration, success)
+
+	var running, maxRunning atomic.Int32
+
+	task := func(err error) func() error {
+		return func() error {
+			n := running.Add(1)
+			defer running.Add(-1)
+
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+
+			return err
+		}
+	}
+
+	r.run("a", task(nil))
+	r.run("b", task(errors.New("failed")))
+	r2.run("c", task(nil))
+	r2.run("d", task(nil))
+
+	finalized := false
+
+	r.finally(func() { finalized = true })
+	r.wait()
+	r2.wait()
+
+	assert.True(t, finalized)
+	assert.LessOrEqual(t, maxRunning.Load(), int32(2))
+	assert.InDelta(t, 1.0, testutil.ToFloat64(success.WithLabelValues("a")), 0)
+	assert.InDelta(t, 0.0, testutil.ToFloat64(success.WithLabelValues("b")), 0)
+	assert.Equal(t, 4, testutil.CollectAndCount(duration))
+}
+
+func TestScrapeRunnerCancelled(t *testing.T) {
+	duration := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "duration"}, []string{"collector"})
+	success := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "success"}, []string{"collector"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := newScrapeRunner(ctx, make(chan struct{}, 1), duration, success)
+
+	// fill the semaphore, so the next call has to wait for the cancelled context
+	r.sem <- struct{}{}
+
+	called := false
+
+	r.run("a", func() error {
+		called = true
+
+		return nil
+	})
+	r.wait()
+
+	assert.False(t, called)
+	assert.InDelta(t, 0.0, testutil.ToFloat64(success.WithLabelValues("a")), 0)
+}
+
+func scrape(t *testing.T, conf *config, query string) string {
+	t.Helper()
+
+	sc.Lock()
+	sc.C = conf
+	sc.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, "/scrape?"+query, nil)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	return rec.Body.String()
+}
+
+func newFakeModem(t *testing.T) (*fakemodem.Server, *config) {
+	t.Helper()
+
+	fake := fakemodem.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	conf := &config{
+		Host:     strings.TrimPrefix(srv.URL, "http://"),
+		Username: fakemodem.DefaultUsername,
+		Password: fakemodem.DefaultPassword,
+	}
+
+	return fake, conf
+}
+
+func TestScrapeEndToEnd(t *testing.T) {
+	fake, conf := newFakeModem(t)
+
+	body := scrape(t, conf, "")
+	assert.Contains(t, body, "hitron_coda_up 1\n")
+	assert.Contains(t, body, `hitron_coda_cm_downstream_signal_strength_dbmv{channel="9",modulation="256QAM",port="1"} 4.3`)
+	assert.Contains(t, body, `hitron_coda_cm_upstream_signal_strength_dbmv{channel="3",modulation="64QAM",port="1"} 40.25`)
+	assert.Contains(t, body, `hitron_coda_cm_downstream_ofdm_plc_power_dbmv{fft_type="4K",receiver="0"}`)
+	assert.Contains(t, body, `location="Basement"`)
+	assert.Contains(t, body, `hitron_coda_wifi_client_rssi_db{band="5G",hostname="laptop"`)
+	assert.Contains(t, body, `hitron_coda_wifi_radio_channel{band="5G"} 149`)
+	assert.Contains(t, body, `hitron_coda_wifi_clients{band="5G",ssid="MyWiFi"} 1`)
+	assert.Contains(t, body, `hitron_coda_wifi_radio_transmit_power_ratio{band="5G"} 0.75`)
+	assert.Contains(t, body, `hitron_coda_wifi_radio_info{auto_channel="true",band="2.4G",mode="11b/g/n"} 1`)
+	assert.Contains(t, body, `hitron_coda_wifi_ssid_enabled{band="2.4G",ssid="MyWiFi-Guest"} 0`)
+	assert.Contains(t, body, `hitron_coda_wifi_ssid_info{band="2.4G",broadcast="true",guest="true",security="WPA2-PSK",ssid="MyWiFi-Guest"} 1`)
+	assert.Contains(t, body, `hitron_coda_scrape_collector_success{collector="cm_dsinfo"} 1`)
+	assert.NotContains(t, body, `hitron_coda_scrape_collector_success{collector="cm_dsinfo"} 0`)
+	assert.Contains(t, body, `hitron_coda_cm_channel_health{channel="9",check="snr",direction="downstream"} 0`)
+	assert.Contains(t, body, `hitron_coda_cm_channel_health{channel="3",check="power",direction="upstream"} 0`)
+	assert.Contains(t, body, "hitron_coda_cm_signal_health 0\n")
+	assert.Contains(t, body, `hitron_coda_cm_log_events_total{event_id="82000200",priority="critical"} 2`)
+	assert.Contains(t, body, "hitron_coda_cm_log_last_event_timestamp_seconds 1.791965553e+09\n")
+	assert.Contains(t, body, "hitron_coda_cm_network_access_allowed 1\n")
+	assert.Contains(t, body, `hitron_coda_cm_bonded_channels{direction="downstream"} 4`)
+	assert.Contains(t, body, `hitron_coda_router_lan_host_info{address_source="Static",hostname="nas",interface="Ethernet",ip="192.168.0.2",mac_addr="00:11:32:00:00:03"} 1`)
+	assert.Contains(t, body, `hitron_coda_router_lan_hosts{interface="Ethernet"} 2`)
+	assert.Contains(t, body, `hitron_coda_router_lan_host_lease_expiry_timestamp_seconds{ip="192.168.0.10",mac_addr="a4:83:e7:00:00:01"} 1.792224e+09`)
+	assert.NotContains(t, body, `hitron_coda_router_lan_host_lease_expiry_timestamp_seconds{ip="192.168.0.2"`)
+	assert.Contains(t, body, `hitron_coda_cm_provisioning_step_success{step="registration"} 1`)
+	assert.Contains(t, body, `hitron_coda_cm_provisioning_info{bpi_status="Enabled",config_file="docsis31_1g_50m.cfg",docsis_version="3.1"`)
+
+	// the session is reused
+	body = scrape(t, conf, "")
+	assert.Equal(t, 1, fake.Logins())
+
+	// and log events aren't counted twice
+	assert.Contains(t, body, `hitron_coda_cm_log_events_total{event_id="82000200",priority="critical"} 2`)
+
+	// and logged in again when it expires
+	fake.ExpireSessions()
+
+	body = scrape(t, conf, "")
+	assert.Contains(t, body, "hitron_coda_up 1\n")
+	assert.Contains(t, body, `hitron_coda_scrape_collector_success{collector="cm_dsinfo"} 1`)
+	assert.Equal(t, 2, fake.Logins())
+
+	// only the selected collectors are run
+	body = scrape(t, conf, "collect[]=cm")
+	assert.Contains(t, body, `hitron_coda_scrape_collector_success{collector="cm_dsinfo"} 1`)
+	assert.Contains(t, body, `hitron_coda_scrape_collector_success{collector="cm_dsofdm"} 1`)
+	assert.NotContains(t, body, `collector="wifi_client"`)
+
+	body = scrape(t, conf, "collect[]=ofdm")
+	assert.Contains(t, body, `hitron_coda_scrape_collector_success{collector="cm_dsofdm"} 1`)
+	assert.NotContains(t, body, `collector="cm_dsinfo"`)
+}
+
+func TestScrapeLANHostsHashed(t *testing.T) {
+	_, conf := newFakeModem(t)
+	conf.LANHosts.HashIdentifiers = true
+	conf.hashKey = []byte("secret")
+
+	body := scrape(t, conf, "collect[]=lan")
+	assert.Contains(t, body, `hitron_coda_router_lan_hosts{interface="Ethernet"} 2`)
+	assert.Contains(t, body, `hostname="`+hashIdentifier(conf.hashKey, "nas")+`"`)
+	assert.Contains(t, body, `mac_addr="`+hashIdentifier(conf.hashKey, "00:11:32:00:00:03")+`"`)
+	assert.NotContains(t, body, "nas")
+	assert.NotContains(t, body, "00:11:32:00:00:03")
+}
+
+func TestScrapeWiFiClientLabels(t *testing.T) {
+	_, conf := newFakeModem(t)
+	conf.WiFiClients = wifiClientsConfig{
+		Labels:     map[string]string{"hostname": labelDrop, "mac_addr": labelDrop},
+		MaxClients: 1,
+	}
+
+	dropped := testutil.ToFloat64(exporterWiFiClientsDropped.WithLabelValues("limit"))
+
+	body := scrape(t, conf, "collect[]=wifi")
+	assert.Contains(t, body, `hitron_coda_wifi_client_rssi_db{band="5G",hostname="",mac_addr="",phy_mode="11ac",ssid="MyWiFi"} -52`)
+	assert.NotContains(t, body, `band="2.4G",hostname=""`)
+	assert.NotContains(t, body, "laptop")
+
+	assert.Equal(t, dropped+1, testutil.ToFloat64(exporterWiFiClientsDropped.WithLabelValues("limit")))
+}
+
+func TestScrapeChannelDropped(t *testing.T) {
+	fake, conf := newFakeModem(t)
+
+	body := scrape(t, conf, "collect[]=cm")
+	assert.Contains(t, body, `hitron_coda_cm_bonded_channels{direction="downstream"} 4`)
+	assert.Contains(t, body, `hitron_coda_cm_bonded_channels{direction="upstream"} 2`)
+	assert.Contains(t, body, `hitron_coda_cm_channel_locked{channel="10",direction="downstream"} 1`)
+	assert.Contains(t, body, "hitron_coda_cm_channel_set_changes_total 0\n")
+
+	// channel 10 drops out
+	dsinfo := `{"errCode": "000", "errMsg": "", "Freq_List": [
+		{"portId": "1", "modulation": "256QAM", "signalStrength": "4.300", "snr": "40.366", "channelId": "9"},
+		{"portId": "3", "modulation": "256QAM", "signalStrength": "3.900", "snr": "40.366", "channelId": "11"},
+		{"portId": "4", "modulation": "256QAM", "signalStrength": "3.700", "snr": "38.983", "channelId": "12"}
+	]}`
+	fake.Fixtures = overlayFS{fstest.MapFS{"CM/DsInfo.json": {Data: []byte(dsinfo)}}, fakemodem.Fixtures()}
+
+	body = scrape(t, conf, "collect[]=cm")
+	assert.Contains(t, body, `hitron_coda_cm_bonded_channels{direction="downstream"} 3`)
+	assert.Contains(t, body, `hitron_coda_cm_channel_locked{channel="10",direction="downstream"} 0`)
+	assert.Contains(t, body, `hitron_coda_cm_channel_locked{channel="11",direction="downstream"} 1`)
+	assert.Contains(t, body, "hitron_coda_cm_channel_set_changes_total 1\n")
+}
+
+func TestScrapeDeviceRestarted(t *testing.T) {
+	fake, conf := newFakeModem(t)
+
+	body := scrape(t, conf, "collect[]=router")
+	assert.Contains(t, body, "hitron_coda_device_restarts_total 0\n")
+	assert.Contains(t, body, `hitron_coda_counter_resets_total{metric="hitron_coda_router_wan_receive_bytes_total"} 0`)
+	assert.NotContains(t, body, "hitron_coda_device_last_restart_timestamp_seconds")
+
+	// the device has restarted, so its uptimes and counters have gone back to 0
+	sysinfo := `{"errCode": "000", "errMsg": "", "LanName": "LAN", "WanName": "WAN", "RouterMode": "Dualstack",
+		"PrivLanIP": "192.168.0.1/24", "WanIP": "203.0.113.10/22,2001:db8::1/128", "DNS": "192.0.2.53,2001:db8::53",
+		"RFMac": "84:0b:7c:01:02:04", "SystemTime": "Sat Oct 17 12:34:56 2026", "SystemLanUptime": "120", "SystemWanUptime": "60",
+		"LanRx": "1000", "LanTx": "1000", "WanRx": "1000", "WanTx": "1000", "WanRxPkts": "10", "WanTxPkts": "10"}`
+	fake.Fixtures = overlayFS{fstest.MapFS{"Router/SysInfo.json": {Data: []byte(sysinfo)}}, fakemodem.Fixtures()}
+
+	before := time.Now()
+
+	body = scrape(t, conf, "collect[]=router")
+	assert.Contains(t, body, "hitron_coda_device_restarts_total 1\n")
+	assert.Contains(t, body, "hitron_coda_device_wan_restarts_total 0\n")
+	assert.Contains(t, body, `hitron_coda_counter_resets_total{metric="hitron_coda_router_wan_receive_bytes_total"} 1`)
+	assert.Contains(t, body, "hitron_coda_device_last_restart_timestamp_seconds ")
+
+	state := states.get(conf.Host)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	assert.WithinDuration(t, before.Add(-120*time.Second), state.resets.lastRestart, 2*time.Second)
+}
+
+// overlayFS serves files from the first filesystem which has them
+type overlayFS []fs.FS
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	for _, fsys := range o[:len(o)-1] {
+		if f, err := fsys.Open(name); err == nil {
+			return f, nil
+		}
+	}
+
+	return o[len(o)-1].Open(name)
+}
+
+func TestScrapeAuthFailure(t *testing.T) {
+	fake, conf := newFakeModem(t)
+	fake.FailAuth = true
+
+	body := scrape(t, conf, "")
+	assert.Contains(t, body, "hitron_coda_up 0\n")
+	assert.NotContains(t, body, "hitron_coda_cm_")
+}
+
+func TestScrapeMalformedResponse(t *testing.T) {
+	fake, conf := newFakeModem(t)
+	fake.Malformed = []string{"CM/DsInfo"}
+
+	body := scrape(t, conf, "")
+	assert.Contains(t, body, "hitron_coda_up 1\n")
+	assert.Contains(t, body, `hitron_coda_scrape_collector_success{collector="cm_dsinfo"} 0`)
+	assert.Contains(t, body, `hitron_coda_scrape_collector_success{collector="cm_usinfo"} 1`)
+	assert.NotContains(t, body, "hitron_coda_cm_downstream_signal_strength_dbmv")
+
+	// upstream health is still known, but not the overall health
+	assert.Contains(t, body, `hitron_coda_cm_channel_health{channel="3",check="power",direction="upstream"} 0`)
+	assert.NotContains(t, body, `direction="downstream"`)
+	assert.NotContains(t, body, "hitron_coda_cm_signal_health")
+}
+
+func TestScrapeCancelledOnTimeout(t *testing.T) {
+	fake, conf := newFakeModem(t)
+
+	// log in first
+	scrape(t, conf, "")
+
+	fake.SetLatency(time.Second)
+
+	conf.MaxTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	body := scrape(t, conf, "")
+
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Contains(t, body, `hitron_coda_scrape_collector_success{collector="cm_dsinfo"} 0`)
+	assert.NotContains(t, body, "hitron_coda_cm_downstream_signal_strength_dbmv")
+}
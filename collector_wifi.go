@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
+	"time"
 
 	hitron "github.com/hairyhenderson/hitron_coda"
 	"github.com/prometheus/client_golang/prometheus"
@@ -11,18 +13,41 @@ import (
 
 // wifiCollector tracks interesting metrics from the hitron CM* APIs
 type wifiCollector struct {
-	ctx    context.Context
-	client func() *hitron.CableModem
+	ctx     context.Context
+	session *session
+	state   *targetState
+
+	// clients controls the labels of per-client metrics
+	clients wifiClientsConfig
+	hashKey []byte
 
 	clientStats struct {
 		rssi      *prometheus.GaugeVec
 		dataRate  *prometheus.GaugeVec
 		bandwidth *prometheus.GaugeVec
 	}
+	history struct {
+		clients         *prometheus.GaugeVec
+		connects        *prometheus.CounterVec
+		disconnects     *prometheus.CounterVec
+		sessionDuration *prometheus.Desc
+	}
+	radio struct {
+		info         *prometheus.GaugeVec
+		enabled      *prometheus.GaugeVec
+		channel      *prometheus.GaugeVec
+		channelWidth *prometheus.GaugeVec
+		txPower      *prometheus.GaugeVec
+	}
+	ssid struct {
+		info    *prometheus.GaugeVec
+		enabled *prometheus.GaugeVec
+	}
 }
 
-func newWiFiCollector(ctx context.Context, clientProvider func() *hitron.CableModem) wifiCollector {
-	c := wifiCollector{ctx: ctx, client: clientProvider}
+//nolint:funlen
+func newWiFiCollector(ctx context.Context, s *session, state *targetState, clients wifiClientsConfig, hashKey []byte) wifiCollector {
+	c := wifiCollector{ctx: ctx, session: s, state: state, clients: clients, hashKey: hashKey}
 
 	sub := "wifi"
 
@@ -46,6 +71,75 @@ func newWiFiCollector(ctx context.Context, clientProvider func() *hitron.CableMo
 		Help:      "Channel bandwidth, in hertz",
 	}, clientLabels)
 
+	networkLabels := []string{"band", "ssid"}
+	c.history.clients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "clients",
+		Help:      "Number of connected WiFi clients",
+	}, networkLabels)
+	c.history.connects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "client_connects_total",
+		Help:      "Number of times a WiFi client has connected",
+	}, networkLabels)
+	c.history.disconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "client_disconnects_total",
+		Help:      "Number of times a WiFi client has disconnected",
+	}, networkLabels)
+	c.history.sessionDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNS, sub, "client_session_duration_seconds"),
+		"Duration of WiFi client sessions, from when the client was first seen connected until it was first seen disconnected",
+		networkLabels, nil)
+
+	radioLabels := []string{"band"}
+	c.radio.info = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "radio_info",
+		Help:      "A metric with a constant '1' value labeled by each radio's 802.11 mode and channel selection mode.",
+	}, []string{"band", "mode", "auto_channel"})
+	c.radio.enabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "radio_enabled",
+		Help:      "Whether the radio is enabled (1), or not (0)",
+	}, radioLabels)
+	c.radio.channel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "radio_channel",
+		Help:      "The radio's current channel number",
+	}, radioLabels)
+	c.radio.channelWidth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "radio_channel_width_hertz",
+		Help:      "The radio's channel width, in hertz",
+	}, radioLabels)
+	c.radio.txPower = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "radio_transmit_power_ratio",
+		Help:      "The radio's transmit power, as a ratio of the maximum (converted from a percentage)",
+	}, radioLabels)
+
+	c.ssid.info = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "ssid_info",
+		Help:      "A metric with a constant '1' value labeled by each SSID's configuration.",
+	}, []string{"band", "ssid", "security", "guest", "broadcast"})
+	c.ssid.enabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: sub,
+		Name:      "ssid_enabled",
+		Help:      "Whether the SSID is enabled (1), or not (0)",
+	}, []string{"band", "ssid"})
+
 	return c
 }
 
@@ -54,34 +148,75 @@ func (c wifiCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.clientStats.rssi.Describe(ch)
 	c.clientStats.dataRate.Describe(ch)
 	c.clientStats.bandwidth.Describe(ch)
-}
 
-// Collect implements Prometheus.Collector.
-func (c wifiCollector) Collect(ch chan<- prometheus.Metric) {
-	client := c.client()
-	if client == nil {
-		err := fmt.Errorf("client not initialized: %v", client)
-		slog.ErrorContext(c.ctx, "Error scraping target", "err", err)
-		exporterClientErrors.Inc()
+	c.history.clients.Describe(ch)
+	c.history.connects.Describe(ch)
+	c.history.disconnects.Describe(ch)
+	ch <- c.history.sessionDuration
 
-		return
-	}
+	c.radio.info.Describe(ch)
+	c.radio.enabled.Describe(ch)
+	c.radio.channel.Describe(ch)
+	c.radio.channelWidth.Describe(ch)
+	c.radio.txPower.Describe(ch)
+
+	c.ssid.info.Describe(ch)
+	c.ssid.enabled.Describe(ch)
+}
 
-	wc, err := client.WiFiClient(c.ctx)
+// collect schedules the WiFi API calls on the runner
+func (c wifiCollector) collect(ch chan<- prometheus.Metric, r *scrapeRunner) {
+	r.run("wifi_client", func() error { return c.collectClients(ch) })
+	r.run("wifi_radio", func() error { return c.collectRadios(ch) })
+	r.run("wifi_ssid", func() error { return c.collectSSIDs(ch) })
+}
+
+func (c wifiCollector) collectClients(ch chan<- prometheus.Metric) error {
+	wc, err := call(c.ctx, c.session, (*hitron.CableModem).WiFiClient)
 	if err != nil {
 		slog.ErrorContext(c.ctx, "Error scraping WiFiClient", "err", err)
 		exporterRequestErrors.Inc()
 
-		return
+		return err
 	}
 
+	// with labels dropped, different clients can end up with the same labels
+	seen := map[string]bool{}
+	statuses := make([]clientStatus, 0, len(wc.Clients))
+
 	for _, cl := range wc.Clients {
+		if c.clients.MaxClients > 0 && len(seen) >= c.clients.MaxClients {
+			exporterWiFiClientsDropped.WithLabelValues("limit").Inc()
+
+			continue
+		}
+
+		hostname, mac := c.clients.identify(c.hashKey, cl.Hostname, cl.MACAddr)
+
+		key := strings.Join([]string{cl.Band, hostname, cl.PhyMode, cl.SSID, mac}, "\x00")
+		if seen[key] {
+			exporterWiFiClientsDropped.WithLabelValues("duplicate").Inc()
+
+			continue
+		}
+
+		seen[key] = true
+
+		statuses = append(statuses, clientStatus{
+			Band:     cl.Band,
+			SSID:     cl.SSID,
+			Hostname: hostname,
+			MAC:      mac,
+			PhyMode:  cl.PhyMode,
+			RSSI:     cl.RSSI,
+		})
+
 		l := prometheus.Labels{
 			"band":     cl.Band,
-			"hostname": cl.Hostname,
+			"hostname": hostname,
 			"phy_mode": cl.PhyMode,
 			"ssid":     cl.SSID,
-			"mac_addr": cl.MACAddr.String(),
+			"mac_addr": mac,
 		}
 
 		c.clientStats.rssi.With(l).Set(float64(cl.RSSI))
@@ -93,4 +228,219 @@ func (c wifiCollector) Collect(ch chan<- prometheus.Metric) {
 	c.clientStats.rssi.Collect(ch)
 	c.clientStats.dataRate.Collect(ch)
 	c.clientStats.bandwidth.Collect(ch)
+
+	c.state.mu.Lock()
+	c.state.status.Clients = statuses
+	c.state.mu.Unlock()
+
+	c.collectHistory(ch, wc.Clients)
+
+	return nil
+}
+
+// collectHistory tracks clients across scrapes
+func (c wifiCollector) collectHistory(ch chan<- prometheus.Metric, clients []hitron.WiFiClientEntry) {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	st := &c.state.wifi
+	st.update(time.Now(), clients)
+
+	for n, count := range st.counts() {
+		c.history.clients.WithLabelValues(n.band, n.ssid).Set(float64(count))
+	}
+
+	for n, v := range st.connects {
+		c.history.connects.WithLabelValues(n.band, n.ssid).Add(v)
+	}
+
+	for n, v := range st.disconnects {
+		c.history.disconnects.WithLabelValues(n.band, n.ssid).Add(v)
+	}
+
+	c.history.clients.Collect(ch)
+	c.history.connects.Collect(ch)
+	c.history.disconnects.Collect(ch)
+
+	for n, h := range st.durations {
+		ch <- prometheus.MustNewConstHistogram(c.history.sessionDuration,
+			h.count, h.sum, h.buckets, n.band, n.ssid)
+	}
+}
+
+func (c wifiCollector) collectRadios(ch chan<- prometheus.Metric) error {
+	radios, err := call(c.ctx, c.session, (*hitron.CableModem).WiFiRadios)
+	if err != nil {
+		slog.ErrorContext(c.ctx, "Error scraping WiFiRadios", "err", err)
+		exporterRequestErrors.Inc()
+
+		return err
+	}
+
+	for _, radio := range radios.Radios {
+		c.radio.info.WithLabelValues(radio.Band, radio.Mode, strconv.FormatBool(radio.AutoChannel)).Set(1)
+		c.radio.enabled.WithLabelValues(radio.Band).Set(boolFloat(radio.Enabled))
+		c.radio.channel.WithLabelValues(radio.Band).Set(float64(radio.Channel))
+		c.radio.channelWidth.WithLabelValues(radio.Band).Set(float64(radio.ChannelWidth))
+		//nolint:gomnd
+		c.radio.txPower.WithLabelValues(radio.Band).Set(float64(radio.TxPower) / 100)
+	}
+
+	c.radio.info.Collect(ch)
+	c.radio.enabled.Collect(ch)
+	c.radio.channel.Collect(ch)
+	c.radio.channelWidth.Collect(ch)
+	c.radio.txPower.Collect(ch)
+
+	return nil
+}
+
+func (c wifiCollector) collectSSIDs(ch chan<- prometheus.Metric) error {
+	ssids, err := call(c.ctx, c.session, (*hitron.CableModem).WiFiSSIDs)
+	if err != nil {
+		slog.ErrorContext(c.ctx, "Error scraping WiFiSSIDs", "err", err)
+		exporterRequestErrors.Inc()
+
+		return err
+	}
+
+	for _, ssid := range ssids.SSIDs {
+		c.ssid.info.With(prometheus.Labels{
+			"band":      ssid.Band,
+			"ssid":      ssid.Name,
+			"security":  ssid.Security,
+			"guest":     strconv.FormatBool(ssid.Guest),
+			"broadcast": strconv.FormatBool(ssid.Broadcast),
+		}).Set(1)
+		c.ssid.enabled.WithLabelValues(ssid.Band, ssid.Name).Set(boolFloat(ssid.Enabled))
+	}
+
+	c.ssid.info.Collect(ch)
+	c.ssid.enabled.Collect(ch)
+
+	return nil
+}
+
+// wifiSessionBuckets are the buckets for the client session duration
+// histogram, from a minute to a week
+//
+//nolint:gomnd
+var wifiSessionBuckets = []float64{
+	60, 300, 900, 1800, 3600, 2 * 3600, 4 * 3600, 8 * 3600, 12 * 3600,
+	24 * 3600, 2 * 24 * 3600, 7 * 24 * 3600,
+}
+
+// wifiState tracks WiFi clients across scrapes, so that connects, disconnects
+// and session durations can be counted without per-client labels
+type wifiState struct {
+	// clients holds the clients seen in the last scrape, keyed by MAC address
+	clients     map[string]wifiSession
+	connects    map[wifiNetwork]float64
+	disconnects map[wifiNetwork]float64
+	durations   map[wifiNetwork]*histogramState
+}
+
+// wifiNetwork identifies the band and SSID a client is connected to
+type wifiNetwork struct {
+	band string
+	ssid string
+}
+
+// wifiSession is a client's connection to a band and SSID
+type wifiSession struct {
+	since   time.Time
+	network wifiNetwork
+	// started is false for clients which were already connected when the
+	// exporter first saw them, as their session's duration isn't known
+	started bool
+}
+
+// histogramState holds the observations for a histogram
+type histogramState struct {
+	buckets map[float64]uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *histogramState) observe(v float64, buckets []float64) {
+	if h.buckets == nil {
+		h.buckets = make(map[float64]uint64, len(buckets))
+	}
+
+	for _, b := range buckets {
+		if v <= b {
+			h.buckets[b]++
+		}
+	}
+
+	h.sum += v
+	h.count++
+}
+
+// update compares the currently-connected clients with those seen in the last
+// update. A client which moves to a different band or SSID is counted as
+// disconnecting from one and connecting to the other.
+func (s *wifiState) update(now time.Time, clients []hitron.WiFiClientEntry) {
+	first := s.clients == nil
+
+	if first {
+		s.connects = map[wifiNetwork]float64{}
+		s.disconnects = map[wifiNetwork]float64{}
+		s.durations = map[wifiNetwork]*histogramState{}
+	}
+
+	current := make(map[string]wifiSession, len(clients))
+
+	for _, cl := range clients {
+		key := cl.MACAddr.String()
+		if key == "" {
+			key = cl.Hostname
+		}
+
+		network := wifiNetwork{band: cl.Band, ssid: cl.SSID}
+
+		prev, ok := s.clients[key]
+		if ok && prev.network == network {
+			current[key] = prev
+
+			continue
+		}
+
+		current[key] = wifiSession{since: now, network: network, started: !first}
+
+		if !first {
+			s.connects[network]++
+		}
+	}
+
+	for key, prev := range s.clients {
+		if cur, ok := current[key]; ok && cur.network == prev.network {
+			continue
+		}
+
+		s.disconnects[prev.network]++
+
+		if prev.started {
+			h, ok := s.durations[prev.network]
+			if !ok {
+				h = &histogramState{}
+				s.durations[prev.network] = h
+			}
+
+			h.observe(now.Sub(prev.since).Seconds(), wifiSessionBuckets)
+		}
+	}
+
+	s.clients = current
+}
+
+// counts returns the number of currently-connected clients per network
+func (s *wifiState) counts() map[wifiNetwork]int {
+	out := map[wifiNetwork]int{}
+
+	for _, cl := range s.clients {
+		out[cl.network]++
+	}
+
+	return out
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	hitron "github.com/hairyhenderson/hitron_coda"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWiFiStateUpdate(t *testing.T) {
+	mac := func(s string) net.HardwareAddr {
+		m, _ := net.ParseMAC(s)
+
+		return m
+	}
+
+	laptop := hitron.WiFiClientEntry{Band: "5G", SSID: "MyWiFi", Hostname: "laptop", MACAddr: mac("a4:83:e7:00:00:01")}
+	thermostat := hitron.WiFiClientEntry{Band: "2.4G", SSID: "MyWiFi", Hostname: "thermostat", MACAddr: mac("18:b4:30:00:00:02")}
+	phone := hitron.WiFiClientEntry{Band: "5G", SSID: "MyWiFi", Hostname: "phone", MACAddr: mac("3c:22:fb:00:00:03")}
+
+	fiveG := wifiNetwork{band: "5G", ssid: "MyWiFi"}
+	twoG := wifiNetwork{band: "2.4G", ssid: "MyWiFi"}
+
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	s := &wifiState{}
+
+	// clients already connected aren't counted as connecting
+	s.update(t0, []hitron.WiFiClientEntry{laptop, thermostat})
+	assert.Empty(t, s.connects)
+	assert.Equal(t, map[wifiNetwork]int{fiveG: 1, twoG: 1}, s.counts())
+
+	s.update(t0.Add(time.Minute), []hitron.WiFiClientEntry{laptop, thermostat, phone})
+	assert.Equal(t, map[wifiNetwork]float64{fiveG: 1}, s.connects)
+	assert.Empty(t, s.disconnects)
+
+	// the laptop disconnects, but its session's duration isn't known
+	s.update(t0.Add(2*time.Minute), []hitron.WiFiClientEntry{thermostat, phone})
+	assert.Equal(t, map[wifiNetwork]float64{fiveG: 1}, s.disconnects)
+	assert.Empty(t, s.durations)
+
+	// the phone roams to 2.4G
+	phone.Band = "2.4G"
+	s.update(t0.Add(11*time.Minute), []hitron.WiFiClientEntry{thermostat, phone})
+	assert.Equal(t, map[wifiNetwork]float64{fiveG: 1, twoG: 1}, s.connects)
+	assert.Equal(t, map[wifiNetwork]float64{fiveG: 2}, s.disconnects)
+	assert.Equal(t, map[wifiNetwork]int{twoG: 2}, s.counts())
+
+	h := s.durations[fiveG]
+	assert.Equal(t, uint64(1), h.count)
+	assert.InDelta(t, 600.0, h.sum, 0.001)
+	assert.Equal(t, uint64(0), h.buckets[300])
+	assert.Equal(t, uint64(1), h.buckets[900])
+	assert.Equal(t, uint64(1), h.buckets[7*24*3600])
+}
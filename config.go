@@ -1,51 +1,598 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type config struct {
+	// Host is the default target, used when no target is given in the request
+	Host string
+	// Username and Password are the default credentials, used when no auth
+	// profile is selected
+	Username string
+	Password secret
+	// PasswordFile and PasswordCommand are alternatives to Password, to read
+	// the password from a file, or from the output of a command
+	PasswordFile    string   `yaml:"password_file"`
+	PasswordCommand []string `yaml:"password_command"`
+
+	// Auths is a map of named credential profiles
+	Auths map[string]authConfig
+	// Modules is a map of named scrape settings, which can be shared between
+	// targets
+	Modules map[string]moduleConfig
+	// Targets is a map of per-target settings, keyed by host
+	Targets map[string]targetConfig
+
+	// MaxTimeout is the maximum duration of any scrape, regardless of the
+	// timeout requested by Prometheus
+	MaxTimeout time.Duration `yaml:"max_timeout"`
+
+	// SignalThresholds overrides the built-in thresholds used to evaluate
+	// the health of each channel, keyed by direction and modulation
+	SignalThresholds thresholdTable `yaml:"signal_thresholds"`
+
+	// HashKey is the key used to hash identifiers such as MAC addresses and
+	// hostnames, when hashing is enabled
+	HashKey secret `yaml:"hash_key"`
+	// LANHosts holds settings for the lan collector
+	LANHosts lanHostsConfig `yaml:"lan_hosts"`
+	// WiFiClients holds settings for the per-client WiFi metrics
+	WiFiClients wifiClientsConfig `yaml:"wifi_clients"`
+
+	// hashKey is the HashKey, or a random key when none is configured
+	hashKey []byte
+	// hash is the checksum of the config file this was loaded from
+	hash string
+	// redactedHash is the checksum of the config with secrets redacted, which
+	// is safe to publish
+	redactedHash string
+}
+
+type lanHostsConfig struct {
+	// HashIdentifiers replaces MAC addresses and hostnames with a keyed hash
+	HashIdentifiers bool `yaml:"hash_identifiers"`
+}
+
+type wifiClientsConfig struct {
+	// Labels sets how each identifying label (hostname and mac_addr) is
+	// exported: keep (the default), drop, or hash
+	Labels map[string]string
+	// AllowList is a list of MAC addresses and hostnames of clients which are
+	// always exported with their labels unchanged
+	AllowList []string `yaml:"allow_list"`
+	// MaxClients is the maximum number of clients to export per-client metrics
+	// for in each scrape - unlimited when 0
+	MaxClients int `yaml:"max_clients"`
+	// NamesFile is a YAML file mapping MAC addresses to friendly names, which
+	// are used in place of the hostnames reported by the device
+	NamesFile string `yaml:"names_file"`
+
+	// names is the mapping read from NamesFile, keyed by MAC address
+	names map[string]string
+}
+
+type authConfig struct {
+	Username        string
+	Password        secret
+	PasswordFile    string   `yaml:"password_file"`
+	PasswordCommand []string `yaml:"password_command"`
+}
+
+type moduleConfig struct {
+	// Collectors is the list of collectors to enable - all are enabled when
+	// empty
+	Collectors []string
+	// DisabledCollectors is a list of collectors to disable
+	DisabledCollectors []string `yaml:"disabled_collectors"`
+	// Timeout is the maximum duration of a scrape
+	Timeout time.Duration
+}
+
+type targetConfig struct {
+	// Auth is the name of the auth profile to use for this target
+	Auth string
+	// Module is the name of the module to use for this target
+	Module string
+	// Location is used as the device's location, overriding the location
+	// configured on the device itself
+	Location string
+	// PollInterval enables polling the target in the background, serving
+	// scrapes from the results of the last successful poll
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// PollStaleness is how long the results of a poll are served for, after
+	// which they're dropped - defaults to 3 poll intervals
+	PollStaleness time.Duration `yaml:"poll_staleness"`
+
+	moduleConfig `yaml:",inline"`
+}
+
+// scrapeTarget holds the resolved settings for scraping a single device
+type scrapeTarget struct {
 	Host     string
 	Username string
-	Password string
+	Password secret
+	Location string
+
+	// SignalThresholds overrides the built-in signal thresholds
+	SignalThresholds thresholdTable
+	LANHosts         lanHostsConfig
+	WiFiClients      wifiClientsConfig
+
+	hashKey []byte
+
+	moduleConfig
 }
 
-// parse a config file
+var errNoTarget = errors.New("'target' parameter must be specified")
+
+// errUnknownTarget is the error for targets which aren't configured - the
+// configured credentials must never be sent to arbitrary hosts
+var errUnknownTarget = errors.New("target is not configured")
+
+// collectorNames is the list of all valid collector names
+var collectorNames = []string{"router", "cm", "ofdm", "wifi", "log", "docsis", "lan"}
+
+// parse a config file. Unknown fields are errors, and all problems found are
+// returned together.
 func parse(in io.Reader) (*config, error) {
 	out := &config{}
-	dec := yaml.NewDecoder(in)
 
-	err := dec.Decode(out)
+	var doc yaml.Node
+
+	err := yaml.NewDecoder(in).Decode(&doc)
 	if err != nil && err != io.EOF {
 		return out, err
 	}
 
+	var errs []error
+
+	// an empty file decodes to an empty document
+	if doc.Kind != 0 {
+		errs = checkFields(&doc, reflect.TypeOf(out), "")
+
+		var typeErr *yaml.TypeError
+
+		err = doc.Decode(out)
+
+		switch {
+		case errors.As(err, &typeErr):
+			for _, msg := range typeErr.Errors {
+				errs = append(errs, errors.New(msg))
+			}
+		case err != nil:
+			errs = append(errs, err)
+		}
+	}
+
+	if err := out.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return out, errors.Join(errs...)
+	}
+
+	err = out.loadSecrets()
+	if err != nil {
+		return out, err
+	}
+
+	err = out.WiFiClients.loadNames()
+	if err != nil {
+		return out, err
+	}
+
+	out.WiFiClients.normalizeAllowList()
+
+	out.initHashKey()
+
 	return out, nil
 }
 
+// initHashKey sets the key for hashing identifiers. When hashing is enabled
+// without a configured key, a random key is used, so hashes change whenever the
+// exporter restarts.
+func (c *config) initHashKey() {
+	switch {
+	case c.HashKey != "":
+		c.hashKey = []byte(c.HashKey)
+	case c.LANHosts.HashIdentifiers, c.WiFiClients.hashes():
+		slog.Warn("No hash_key configured, using a random key - hashed identifiers will change when the exporter restarts")
+
+		c.hashKey = randomHashKey()
+	}
+}
+
+// loadSecrets expands environment variables in the credentials and hash key,
+// and reads passwords from their files or commands
+func (c *config) loadSecrets() error {
+	var err error
+
+	c.Username, c.Password, err = loadCredentials(c.Username, c.Password, c.PasswordFile, c.PasswordCommand)
+	if err != nil {
+		return err
+	}
+
+	for name, a := range c.Auths {
+		a.Username, a.Password, err = loadCredentials(a.Username, a.Password, a.PasswordFile, a.PasswordCommand)
+		if err != nil {
+			return configError{path: "auths[" + name + "]", msg: err.Error()}
+		}
+
+		c.Auths[name] = a
+	}
+
+	hashKey, err := expandEnv(string(c.HashKey))
+	if err != nil {
+		return configError{path: "hash_key", msg: err.Error()}
+	}
+
+	c.HashKey = secret(hashKey)
+
+	return nil
+}
+
+func loadCredentials(username string, password secret, file string, command []string) (string, secret, error) {
+	username, err := expandEnv(username)
+	if err != nil {
+		return "", "", fmt.Errorf("username: %w", err)
+	}
+
+	password, err = resolvePassword(password, file, command)
+	if err != nil {
+		return "", "", err
+	}
+
+	return username, password, nil
+}
+
+// validate the config, returning all problems found
+//
+//nolint:funlen,gocyclo
+func (c *config) validate() error {
+	var errs []error
+
+	add := func(path string, err error) {
+		if err != nil {
+			errs = append(errs, configError{path: path, msg: err.Error()})
+		}
+	}
+
+	if c.Host == "" && len(c.Targets) == 0 {
+		add("host", errors.New("must be set, unless targets are configured"))
+	}
+
+	if c.Host != "" {
+		add("host", validateHost(c.Host))
+	}
+
+	if c.needsDefaultCredentials() {
+		if c.Username == "" {
+			add("username", errors.New("must be set, unless every target has an auth"))
+		}
+
+		if passwordSources(c.Password, c.PasswordFile, c.PasswordCommand) == 0 {
+			add("password", errors.New("password, password_file, or password_command must be set, unless every target has an auth"))
+		}
+	}
+
+	if passwordSources(c.Password, c.PasswordFile, c.PasswordCommand) > 1 {
+		add("password", errors.New("only one of password, password_file, and password_command can be set"))
+	}
+
+	if c.MaxTimeout < 0 {
+		add("max_timeout", fmt.Errorf("invalid timeout %s", c.MaxTimeout))
+	}
+
+	// the overrides are merged with the defaults bound by bound, so the
+	// merged ranges need checking
+	add("signal_thresholds", defaultSignalThresholds.merge(c.SignalThresholds).validate())
+	add("wifi_clients", c.WiFiClients.validate())
+
+	for _, name := range slices.Sorted(maps.Keys(c.Auths)) {
+		a := c.Auths[name]
+		path := "auths[" + name + "]"
+
+		if a.Username == "" {
+			add(path+".username", errors.New("must be set"))
+		}
+
+		switch passwordSources(a.Password, a.PasswordFile, a.PasswordCommand) {
+		case 0:
+			add(path+".password", errors.New("password, password_file, or password_command must be set"))
+		case 1:
+		default:
+			add(path+".password", errors.New("only one of password, password_file, and password_command can be set"))
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.Modules)) {
+		for _, err := range c.Modules[name].validate() {
+			add("modules["+name+"]", err)
+		}
+	}
+
+	for _, host := range slices.Sorted(maps.Keys(c.Targets)) {
+		t := c.Targets[host]
+		path := "targets[" + host + "]"
+
+		add(path, validateHost(host))
+
+		if _, ok := c.Auths[t.Auth]; t.Auth != "" && !ok {
+			add(path+".auth", fmt.Errorf("unknown auth %q", t.Auth))
+		}
+
+		if _, ok := c.Modules[t.Module]; t.Module != "" && !ok {
+			add(path+".module", fmt.Errorf("unknown module %q", t.Module))
+		}
+
+		if t.PollInterval < 0 {
+			add(path+".poll_interval", fmt.Errorf("invalid interval %s", t.PollInterval))
+		}
+
+		if t.PollStaleness < 0 || (t.PollStaleness > 0 && t.PollStaleness < t.PollInterval) {
+			add(path+".poll_staleness", fmt.Errorf("invalid staleness %s, must be at least the poll_interval", t.PollStaleness))
+		}
+
+		for _, err := range t.validate() {
+			add(path, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// needsDefaultCredentials returns whether the default username and password
+// are used by any target which can be scraped - the default host, unless it's
+// listed in targets with an auth profile, and each target without one. They're
+// always needed when no targets are configured.
+func (c *config) needsDefaultCredentials() bool {
+	if len(c.Targets) == 0 {
+		return true
+	}
+
+	if c.Host != "" && c.Targets[c.Host].Auth == "" {
+		return true
+	}
+
+	for _, t := range c.Targets {
+		if t.Auth == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+var hostnameRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// validateHost checks that a target is a hostname or IP address, optionally
+// with a port, or an http(s) URL
+func validateHost(host string) error {
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return fmt.Errorf("invalid URL: %w", err)
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+		}
+
+		host = u.Host
+	}
+
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid port %q", port)
+		}
+
+		host = h
+
+		if net.ParseIP(host) != nil {
+			return nil
+		}
+	}
+
+	if !hostnameRegexp.MatchString(host) {
+		return fmt.Errorf("invalid host %q", host)
+	}
+
+	return nil
+}
+
+func (m moduleConfig) validate() []error {
+	var errs []error
+
+	if err := validateCollectors(m.Collectors); err != nil {
+		errs = append(errs, fmt.Errorf("collectors: %w", err))
+	}
+
+	if err := validateCollectors(m.DisabledCollectors); err != nil {
+		errs = append(errs, fmt.Errorf("disabled_collectors: %w", err))
+	}
+
+	if m.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid timeout %s", m.Timeout))
+	}
+
+	return errs
+}
+
+// resolveTarget returns the settings for scraping the given host. The auth and
+// module names are optional, and override the names configured for the target.
+// When host is empty, the default Host is used. Only the default Host and the
+// hosts listed in Targets can be scraped.
+func (c *config) resolveTarget(host, auth, module string) (*scrapeTarget, error) {
+	if host == "" {
+		host = c.Host
+	}
+
+	if host == "" {
+		return nil, errNoTarget
+	}
+
+	tc, ok := c.Targets[host]
+	if !ok && host != c.Host {
+		return nil, fmt.Errorf("%w: %q must be the default host, or listed in targets", errUnknownTarget, host)
+	}
+
+	t := &scrapeTarget{
+		Host:             host,
+		Username:         c.Username,
+		Password:         c.Password,
+		Location:         tc.Location,
+		SignalThresholds: c.SignalThresholds,
+		LANHosts:         c.LANHosts,
+		WiFiClients:      c.WiFiClients,
+		hashKey:          c.hashKey,
+		moduleConfig:     tc.moduleConfig,
+	}
+
+	if auth == "" {
+		auth = tc.Auth
+	}
+
+	if auth != "" {
+		a, ok := c.Auths[auth]
+		if !ok {
+			return nil, fmt.Errorf("unknown auth %q", auth)
+		}
+
+		t.Username = a.Username
+		t.Password = a.Password
+	}
+
+	if module == "" {
+		module = tc.Module
+	}
+
+	if module != "" {
+		m, ok := c.Modules[module]
+		if !ok {
+			return nil, fmt.Errorf("unknown module %q", module)
+		}
+
+		if len(m.Collectors) > 0 {
+			t.Collectors = m.Collectors
+		}
+
+		if len(m.DisabledCollectors) > 0 {
+			t.DisabledCollectors = m.DisabledCollectors
+		}
+
+		if m.Timeout > 0 {
+			t.Timeout = m.Timeout
+		}
+	}
+
+	return t, nil
+}
+
+func validateCollectors(names []string) error {
+	for _, name := range names {
+		if !slices.Contains(collectorNames, name) {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+
+	return nil
+}
+
+// selectCollectors overrides the configured collectors, enabling only the
+// given ones
+func (t *scrapeTarget) selectCollectors(names []string) error {
+	if err := validateCollectors(names); err != nil {
+		return err
+	}
+
+	t.Collectors = names
+	t.DisabledCollectors = nil
+
+	return nil
+}
+
+// collectorParents maps the collectors which are part of another collector to
+// that collector, so that enabling (or disabling) the parent enables (or
+// disables) them too
+var collectorParents = map[string]string{"ofdm": "cm"}
+
+// collectorEnabled returns whether the named collector should be run for this
+// target
+func (t *scrapeTarget) collectorEnabled(name string) bool {
+	parent, hasParent := collectorParents[name]
+
+	if slices.Contains(t.DisabledCollectors, name) || (hasParent && slices.Contains(t.DisabledCollectors, parent)) {
+		return false
+	}
+
+	if len(t.Collectors) == 0 || slices.Contains(t.Collectors, name) {
+		return true
+	}
+
+	return hasParent && slices.Contains(t.Collectors, parent)
+}
+
 type safeConfig struct {
 	C *config
 	sync.RWMutex
 }
 
 func (sc *safeConfig) ReloadConfig(configFile string) (err error) {
-	f, err := os.Open(configFile)
+	b, err := os.ReadFile(configFile)
 	if err != nil {
 		return err
 	}
 
-	conf, err := parse(f)
+	conf, err := parse(bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
 
+	conf.hash = configHash(b)
+
+	redacted, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+
+	conf.redactedHash = configHash(redacted)
+
 	sc.Lock()
 	sc.C = conf
 	sc.Unlock()
 
 	return nil
 }
+
+// configHash returns the SHA-256 checksum of the config file's contents
+func configHash(b []byte) string {
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
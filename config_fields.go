@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configError is a problem with the config file, at the given field path
+type configError struct {
+	path string
+	msg  string
+	line int
+}
+
+func (e configError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.line, e.path, e.msg)
+	}
+
+	return fmt.Sprintf("%s: %s", e.path, e.msg)
+}
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// checkFields returns an error for each key in the YAML document which doesn't
+// correspond to a field of t, so that typos aren't silently ignored. Unlike
+// yaml.Decoder's KnownFields, all unknown fields are reported, along with
+// their paths.
+func checkFields(node *yaml.Node, t reflect.Type, path string) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+
+		return checkFields(node.Content[0], t, path)
+	case yaml.AliasNode:
+		return checkFields(node.Alias, t, path)
+	}
+
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+
+	var errs []error
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		fields := yamlFields(t)
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldPath := joinPath(path, key.Value)
+
+			ft, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, configError{path: fieldPath, msg: "unknown field", line: key.Line})
+
+				continue
+			}
+
+			errs = append(errs, checkFields(value, ft, fieldPath)...)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			errs = append(errs, checkFields(node.Content[i+1], t.Elem(), path+"["+node.Content[i].Value+"]")...)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+
+		for i, item := range node.Content {
+			errs = append(errs, checkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	return errs
+}
+
+// yamlFields returns the types of the struct's fields, keyed by the names
+// they're decoded from, following yaml.v3's naming rules
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("yaml")
+		name, opts, _ := strings.Cut(tag, ",")
+
+		switch {
+		case tag == "-":
+			continue
+		case strings.Contains(opts, "inline"):
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+
+			continue
+		case !f.IsExported():
+			continue
+		case name == "":
+			name = strings.ToLower(f.Name)
+		}
+
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+
+	return path + "." + field
+}
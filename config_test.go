@@ -3,10 +3,18 @@ package main
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// minimalConfig is the least a valid config needs
+const minimalConfig = `host: 192.168.0.1
+username: user
+password: pass
+`
+
 func TestParse(t *testing.T) {
 	in := `host: 192.168.0.1
 username: user
@@ -14,5 +22,200 @@ password: pass
 `
 	c, err := parse(strings.NewReader(in))
 	assert.NoError(t, err)
-	assert.EqualValues(t, &config{"192.168.0.1", "user", "pass"}, c)
+	assert.EqualValues(t, &config{Host: "192.168.0.1", Username: "user", Password: "pass"}, c)
+
+	in = `auths:
+  admin:
+    username: cusadmin
+    password: secret
+modules:
+  signal:
+    collectors: [cm]
+    timeout: 10s
+targets:
+  192.168.0.1:
+    auth: admin
+    location: basement
+    collectors: [router, wifi]
+    timeout: 30s
+`
+	c, err = parse(strings.NewReader(in))
+	assert.NoError(t, err)
+	assert.EqualValues(t, &config{
+		Auths: map[string]authConfig{
+			"admin": {Username: "cusadmin", Password: "secret"},
+		},
+		Modules: map[string]moduleConfig{
+			"signal": {Collectors: []string{"cm"}, Timeout: 10 * time.Second},
+		},
+		Targets: map[string]targetConfig{
+			"192.168.0.1": {
+				Auth:     "admin",
+				Location: "basement",
+				moduleConfig: moduleConfig{
+					Collectors: []string{"router", "wifi"},
+					Timeout:    30 * time.Second,
+				},
+			},
+		},
+	}, c)
+
+	_, err = parse(strings.NewReader("targets: {foo: {auth: bogus}}"))
+	assert.Error(t, err)
+
+	_, err = parse(strings.NewReader("modules: {foo: {collectors: [bogus]}}"))
+	assert.Error(t, err)
+}
+
+func TestParseStrict(t *testing.T) {
+	in := `host: 192.168.0.1
+username: user
+passwrod: pass
+max_timeout: 10s
+auths:
+  admin:
+    username: cusadmin
+targets:
+  bad_host!:
+    auth: admin
+    colectors: [cm]
+    poll_interval: 1m
+    poll_staleness: 30s
+`
+	_, err := parse(strings.NewReader(in))
+	require.Error(t, err)
+
+	// all problems are reported, with their paths
+	problems := strings.Split(err.Error(), "\n")
+	assert.ElementsMatch(t, []string{
+		"line 3: passwrod: unknown field",
+		"line 11: targets[bad_host!].colectors: unknown field",
+		"password: password, password_file, or password_command must be set, unless every target has an auth",
+		"auths[admin].password: password, password_file, or password_command must be set",
+		`targets[bad_host!]: invalid host "bad_host!"`,
+		"targets[bad_host!].poll_staleness: invalid staleness 30s, must be at least the poll_interval",
+	}, problems)
+
+	_, err = parse(strings.NewReader(minimalConfig + "max_timeout: soon"))
+	assert.ErrorContains(t, err, "line 4: cannot unmarshal")
+
+	// an empty config isn't enough
+	_, err = parse(strings.NewReader(""))
+	assert.ErrorContains(t, err, "host: must be set")
+
+	// default credentials aren't needed when every target has an auth
+	_, err = parse(strings.NewReader(`auths: {admin: {username: cusadmin, password: secret}}
+targets: {192.168.0.1: {auth: admin}}`))
+	assert.NoError(t, err)
+}
+
+func TestValidateHost(t *testing.T) {
+	for _, host := range []string{
+		"192.168.0.1", "192.168.0.1:443", "hitron.lan", "modem", "::1", "[fe80::1]:8443",
+		"https://192.168.0.1", "http://hitron.lan:8080",
+	} {
+		assert.NoError(t, validateHost(host), host)
+	}
+
+	for _, host := range []string{
+		"", "bad_host", "192.168.0.1:0", "192.168.0.1:http", "ftp://192.168.0.1", "-modem", "modem.",
+	} {
+		assert.Error(t, validateHost(host), host)
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	c := &config{
+		Host:     "192.168.0.1",
+		Username: "user",
+		Password: "pass",
+		Auths: map[string]authConfig{
+			"admin": {Username: "cusadmin", Password: "secret"},
+		},
+		Modules: map[string]moduleConfig{
+			"signal": {Collectors: []string{"cm"}, Timeout: 10 * time.Second},
+		},
+		Targets: map[string]targetConfig{
+			"192.168.100.1": {
+				Auth:         "admin",
+				Location:     "basement",
+				moduleConfig: moduleConfig{Timeout: 30 * time.Second},
+			},
+		},
+	}
+
+	target, err := c.resolveTarget("", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, &scrapeTarget{Host: "192.168.0.1", Username: "user", Password: "pass"}, target)
+
+	target, err = c.resolveTarget("192.168.0.1", "admin", "")
+	require.NoError(t, err)
+	assert.Equal(t, &scrapeTarget{Host: "192.168.0.1", Username: "cusadmin", Password: "secret"}, target)
+
+	// credentials are only sent to configured hosts
+	_, err = c.resolveTarget("10.0.0.1", "", "")
+	assert.ErrorIs(t, err, errUnknownTarget)
+
+	_, err = c.resolveTarget("10.0.0.1", "admin", "")
+	assert.ErrorIs(t, err, errUnknownTarget)
+
+	target, err = c.resolveTarget("192.168.100.1", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, &scrapeTarget{
+		Host: "192.168.100.1", Username: "cusadmin", Password: "secret", Location: "basement",
+		moduleConfig: moduleConfig{Timeout: 30 * time.Second},
+	}, target)
+
+	target, err = c.resolveTarget("192.168.100.1", "", "signal")
+	require.NoError(t, err)
+	assert.Equal(t, &scrapeTarget{
+		Host: "192.168.100.1", Username: "cusadmin", Password: "secret", Location: "basement",
+		moduleConfig: moduleConfig{Collectors: []string{"cm"}, Timeout: 10 * time.Second},
+	}, target)
+	assert.True(t, target.collectorEnabled("cm"))
+	assert.False(t, target.collectorEnabled("wifi"))
+
+	_, err = c.resolveTarget("192.168.100.1", "bogus", "")
+	assert.Error(t, err)
+
+	_, err = c.resolveTarget("192.168.100.1", "", "bogus")
+	assert.Error(t, err)
+
+	_, err = (&config{}).resolveTarget("", "", "")
+	assert.ErrorIs(t, err, errNoTarget)
+}
+
+func TestCollectorEnabled(t *testing.T) {
+	target := &scrapeTarget{}
+	assert.True(t, target.collectorEnabled("ofdm"))
+
+	target.DisabledCollectors = []string{"ofdm", "wifi"}
+	assert.True(t, target.collectorEnabled("cm"))
+	assert.False(t, target.collectorEnabled("ofdm"))
+	assert.False(t, target.collectorEnabled("wifi"))
+
+	target.Collectors = []string{"cm"}
+	assert.True(t, target.collectorEnabled("cm"))
+	assert.False(t, target.collectorEnabled("router"))
+	assert.False(t, target.collectorEnabled("ofdm"))
+
+	// ofdm is part of cm, unless it's disabled
+	target.DisabledCollectors = nil
+	assert.True(t, target.collectorEnabled("ofdm"))
+
+	target.Collectors = []string{"ofdm"}
+	assert.False(t, target.collectorEnabled("cm"))
+	assert.True(t, target.collectorEnabled("ofdm"))
+
+	target.Collectors = nil
+	target.DisabledCollectors = []string{"cm"}
+	assert.False(t, target.collectorEnabled("ofdm"))
+
+	// selected collectors override both lists
+	require.NoError(t, target.selectCollectors([]string{"cm", "wifi"}))
+	assert.True(t, target.collectorEnabled("wifi"))
+	assert.True(t, target.collectorEnabled("ofdm"))
+	assert.False(t, target.collectorEnabled("router"))
+
+	assert.Error(t, target.selectCollectors([]string{"bogus"}))
 }
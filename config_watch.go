@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// configWatcher detects changes to the config file's contents
+type configWatcher struct {
+	modTime time.Time
+	path    string
+	hash    string
+	size    int64
+}
+
+// newConfigWatcher returns a watcher for the file at path, which was last
+// loaded with the given checksum
+func newConfigWatcher(path, hash string) *configWatcher {
+	return &configWatcher{path: path, hash: hash}
+}
+
+// changed returns whether the file's contents have changed since the last
+// call. The file is only read when its modification time or size has changed.
+func (w *configWatcher) changed() (bool, error) {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return false, err
+	}
+
+	if fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return false, nil
+	}
+
+	b, err := os.ReadFile(w.path)
+	if err != nil {
+		return false, err
+	}
+
+	w.modTime, w.size = fi.ModTime(), fi.Size()
+
+	hash := configHash(b)
+	if hash == w.hash {
+		return false, nil
+	}
+
+	w.hash = hash
+
+	return true, nil
+}
+
+// watchConfig checks the config file for changes every interval, reloading it
+// through reloadCh when it changes, until the context is done
+func watchConfig(ctx context.Context, configFile, hash string, interval time.Duration) {
+	w := newConfigWatcher(configFile, hash)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := w.changed()
+		if err != nil {
+			slog.WarnContext(ctx, "Error checking config file for changes", "err", err)
+
+			continue
+		}
+
+		if !changed {
+			continue
+		}
+
+		slog.InfoContext(ctx, "Config file changed, reloading", "file", configFile)
+
+		// errors are logged, and reported in the reload metrics, by handleHUP
+		rc := make(chan error)
+
+		select {
+		case reloadCh <- rc:
+			<-rc
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigWatcherChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hitron_coda.yml")
+	require.NoError(t, os.WriteFile(path, []byte(minimalConfig), 0o600))
+
+	w := newConfigWatcher(path, configHash([]byte(minimalConfig)))
+
+	changed, err := w.changed()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	require.NoError(t, os.WriteFile(path, []byte(minimalConfig+"max_timeout: 10s\n"), 0o600))
+
+	changed, err = w.changed()
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = w.changed()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// touching the file without changing it isn't a change
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	changed, err = w.changed()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	require.NoError(t, os.Remove(path))
+
+	_, err = w.changed()
+	assert.Error(t, err)
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hitron_coda.yml")
+	require.NoError(t, os.WriteFile(path, []byte(minimalConfig), 0o600))
+
+	reloadCh = make(chan chan error)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go watchConfig(ctx, path, configHash([]byte(minimalConfig)), 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(path, []byte(minimalConfig+"max_timeout: 10s\n"), 0o600))
+
+	select {
+	case rc := <-reloadCh:
+		rc <- nil
+	case <-time.After(5 * time.Second):
+		t.Fatal("config wasn't reloaded")
+	}
+}
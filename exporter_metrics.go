@@ -59,9 +59,47 @@ var (
 			Help:      "Errors with the Hitron CODA client",
 		},
 	)
+	exporterLogins = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNS,
+			Name:      "logins_total",
+			Help:      "Number of times the exporter has logged in to any device",
+		},
+	)
+	exporterWiFiClientsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNS,
+			Name:      "wifi_client_series_dropped_total",
+			Help:      "Number of WiFi clients whose per-client metrics were dropped (across all targets), because of the max_clients limit, or because their labels duplicated another client's",
+		},
+		[]string{"reason"},
+	)
+	configLastReloadSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNS,
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last attempt to load the config file succeeded (1), or not (0)",
+		},
+	)
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNS,
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Time of the last successful load of the config file, in seconds since the epoch",
+		},
+	)
+	configInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNS,
+			Name:      "config_info",
+			Help:      "A metric with a constant '1' value labeled by the SHA-256 checksum of the loaded config, with secrets redacted.",
+		},
+		[]string{"sha256"},
+	)
 )
 
 func initExporterMetrics() {
 	prometheus.MustRegister(buildInfo)
-	prometheus.MustRegister(exporterDuration, exporterDurationSummary, exporterRequestErrors, exporterClientErrors)
+	prometheus.MustRegister(exporterDuration, exporterDurationSummary, exporterRequestErrors, exporterClientErrors, exporterLogins, exporterWiFiClientsDropped)
+	prometheus.MustRegister(configLastReloadSuccessful, configLastReloadSuccessTimestamp, configInfo)
 }
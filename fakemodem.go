@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/hairyhenderson/hitron_coda_exporter/internal/fakemodem"
+)
+
+// fakeModemCommand runs a fake Hitron CODA device, for testing and local
+// development
+type fakeModemCommand struct {
+	cmd *kingpin.CmdClause
+
+	listenAddress string
+	fixturesDir   string
+	username      string
+	password      string
+	malformed     []string
+	latency       time.Duration
+	failAuth      bool
+}
+
+func newFakeModemCommand() *fakeModemCommand {
+	c := &fakeModemCommand{}
+
+	c.cmd = kingpin.Command("fake-modem", "Run a fake Hitron CODA device, serving the device's API from fixtures.")
+	c.cmd.Flag("listen-address", "Address to listen on.").Default(":8080").StringVar(&c.listenAddress)
+	c.cmd.Flag("fixtures", "Directory containing fixtures to serve, instead of the built-in ones.").StringVar(&c.fixturesDir)
+	c.cmd.Flag("username", "Username to accept.").Default(fakemodem.DefaultUsername).StringVar(&c.username)
+	c.cmd.Flag("password", "Password to accept.").Default(fakemodem.DefaultPassword).StringVar(&c.password)
+	c.cmd.Flag("malformed", "API path (e.g. CM/DsInfo) to respond to with malformed JSON. Can be repeated.").StringsVar(&c.malformed)
+	c.cmd.Flag("latency", "Latency to add to every response.").DurationVar(&c.latency)
+	c.cmd.Flag("fail-auth", "Fail every login attempt.").BoolVar(&c.failAuth)
+
+	return c
+}
+
+func (c *fakeModemCommand) run() error {
+	fake := fakemodem.New()
+	fake.Username = c.username
+	fake.Password = c.password
+	fake.Malformed = c.malformed
+	fake.Latency = c.latency
+	fake.FailAuth = c.failAuth
+
+	if c.fixturesDir != "" {
+		fake.Fixtures = os.DirFS(c.fixturesDir)
+	}
+
+	slog.Info("Starting fake Hitron CODA device", "address", c.listenAddress)
+
+	srv := &http.Server{
+		Addr:    c.listenAddress,
+		Handler: fake,
+		//nolint:gomnd
+		ReadHeaderTimeout: 2 * time.Second,
+	}
+
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// readyMaxPollAge is the maximum age of each polled target's last successful
+// poll for the exporter to be ready - the check is disabled when 0
+var readyMaxPollAge time.Duration
+
+// healthResponse is the body of the health endpoints
+type healthResponse struct {
+	Status string `json:"status"`
+	// Reasons explains why the exporter isn't ready
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+func writeHealth(w http.ResponseWriter, code int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// healthyHandler reports that the process is up
+func healthyHandler(w http.ResponseWriter, _ *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "healthy"})
+}
+
+// readyHandler reports whether the exporter is ready to serve scrapes: the
+// config has been loaded and, when readyMaxPollAge is set, every polled target
+// has been polled successfully recently enough
+func readyHandler(w http.ResponseWriter, _ *http.Request) {
+	reasons := readinessProblems(time.Now())
+	if len(reasons) > 0 {
+		writeHealth(w, http.StatusServiceUnavailable, healthResponse{Status: "not ready", Reasons: reasons})
+
+		return
+	}
+
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ready"})
+}
+
+func readinessProblems(now time.Time) []string {
+	var reasons []string
+
+	sc.RLock()
+	loaded := sc.C != nil && sc.C.hash != ""
+	sc.RUnlock()
+
+	if !loaded {
+		reasons = append(reasons, "config not loaded")
+	}
+
+	if readyMaxPollAge <= 0 {
+		return reasons
+	}
+
+	lastSuccesses := polls.lastSuccesses()
+
+	for _, host := range slices.Sorted(maps.Keys(lastSuccesses)) {
+		last := lastSuccesses[host]
+
+		switch age := now.Sub(last); {
+		case last.IsZero():
+			reasons = append(reasons, fmt.Sprintf("target %s: not polled successfully yet", host))
+		case age > readyMaxPollAge:
+			reasons = append(reasons, fmt.Sprintf("target %s: last successful poll was %s ago, more than %s",
+				host, age.Round(time.Second), readyMaxPollAge))
+		}
+	}
+
+	return reasons
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getHealth(t *testing.T, h http.HandlerFunc) (int, healthResponse) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	resp := healthResponse{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	return rec.Code, resp
+}
+
+func TestHealthy(t *testing.T) {
+	code, resp := getHealth(t, healthyHandler)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthResponse{Status: "healthy"}, resp)
+}
+
+func TestReady(t *testing.T) {
+	sc.Lock()
+	sc.C = &config{}
+	sc.Unlock()
+
+	code, resp := getHealth(t, readyHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthResponse{Status: "not ready", Reasons: []string{"config not loaded"}}, resp)
+
+	sc.Lock()
+	sc.C = &config{hash: "abc"}
+	sc.Unlock()
+
+	code, resp = getHealth(t, readyHandler)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthResponse{Status: "ready"}, resp)
+
+	now := time.Now()
+
+	polls.mu.Lock()
+	polls.pollers = map[string]*poller{
+		"192.168.0.1":   {lastSuccess: now.Add(-30 * time.Second)},
+		"192.168.100.1": {lastSuccess: now.Add(-5 * time.Minute)},
+		"10.0.0.1":      {},
+	}
+	polls.mu.Unlock()
+
+	t.Cleanup(func() {
+		polls.mu.Lock()
+		polls.pollers = map[string]*poller{}
+		polls.mu.Unlock()
+
+		readyMaxPollAge = 0
+	})
+
+	// poll ages aren't checked unless enabled
+	assert.Empty(t, readinessProblems(now))
+
+	readyMaxPollAge = time.Minute
+
+	assert.Equal(t, []string{
+		"target 10.0.0.1: not polled successfully yet",
+		"target 192.168.100.1: last successful poll was 5m0s ago, more than 1m0s",
+	}, readinessProblems(now))
+
+	code, resp = getHealth(t, readyHandler)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Len(t, resp.Reasons, 2)
+}
@@ -0,0 +1,111 @@
+{
+  prometheusAlerts+:: {
+    groups+: [
+      {
+        name: 'hitron-coda',
+        rules: [
+          {
+            alert: 'HitronCodaDown',
+            expr: |||
+              hitron_coda_up{%(hitronSelector)s} == 0
+            ||| % $._config,
+            'for': $._config.hitronDownFor,
+            labels: {
+              severity: 'critical',
+            },
+            annotations: {
+              summary: 'Hitron CODA device is unreachable.',
+              description: 'The exporter has been unable to scrape the Hitron CODA device {{ $labels.instance }} for more than %(hitronDownFor)s.' % $._config,
+            },
+          },
+          {
+            alert: 'HitronCodaDownstreamPowerOutOfSpec',
+            expr: |||
+              hitron_coda_cm_downstream_signal_strength_dbmv{%(hitronSelector)s} < %(downstreamPowerMinDbmv)s
+              or
+              hitron_coda_cm_downstream_signal_strength_dbmv{%(hitronSelector)s} > %(downstreamPowerMaxDbmv)s
+            ||| % $._config,
+            'for': $._config.signalOutOfSpecFor,
+            labels: {
+              severity: 'warning',
+            },
+            annotations: {
+              summary: 'Downstream power level is out of spec.',
+              description: 'Downstream channel {{ $labels.channel }} on {{ $labels.instance }} has a power level of {{ $value | printf "%%.1f" }} dBmV, outside of the %(downstreamPowerMinDbmv)s to %(downstreamPowerMaxDbmv)s dBmV range.' % $._config,
+            },
+          },
+          {
+            alert: 'HitronCodaDownstreamSNRLow',
+            expr: |||
+              hitron_coda_cm_downstream_signal_noise_ratio_db{%(hitronSelector)s} < %(downstreamSnrMinDb)s
+            ||| % $._config,
+            'for': $._config.signalOutOfSpecFor,
+            labels: {
+              severity: 'warning',
+            },
+            annotations: {
+              summary: 'Downstream signal-to-noise ratio is low.',
+              description: 'Downstream channel {{ $labels.channel }} on {{ $labels.instance }} has an SNR of {{ $value | printf "%%.1f" }} dB, below %(downstreamSnrMinDb)s dB.' % $._config,
+            },
+          },
+          {
+            alert: 'HitronCodaDownstreamUncorrectableErrors',
+            expr: |||
+              rate(hitron_coda_cm_downstream_uncorrected_blocks{%(hitronSelector)s}[15m]) > %(downstreamUncorrectedRateThreshold)s
+            ||| % $._config,
+            'for': $._config.signalOutOfSpecFor,
+            labels: {
+              severity: 'warning',
+            },
+            annotations: {
+              summary: 'Downstream uncorrectable errors are rising.',
+              description: 'Downstream channel {{ $labels.channel }} on {{ $labels.instance }} is receiving {{ $value | printf "%%.1f" }} uncorrectable blocks per second.',
+            },
+          },
+          {
+            alert: 'HitronCodaUpstreamPowerHigh',
+            expr: |||
+              hitron_coda_cm_upstream_signal_strength_dbmv{%(hitronSelector)s} > %(upstreamPowerMaxDbmv)s
+            ||| % $._config,
+            'for': $._config.signalOutOfSpecFor,
+            labels: {
+              severity: 'warning',
+            },
+            annotations: {
+              summary: 'Upstream power level is too high.',
+              description: 'Upstream channel {{ $labels.channel }} on {{ $labels.instance }} has a power level of {{ $value | printf "%%.1f" }} dBmV, above %(upstreamPowerMaxDbmv)s dBmV.' % $._config,
+            },
+          },
+          {
+            alert: 'HitronCodaWANRestarted',
+            expr: |||
+              resets(hitron_coda_router_wan_uptime_seconds{%(hitronSelector)s}[15m]) > 0
+            ||| % $._config,
+            labels: {
+              severity: 'info',
+            },
+            annotations: {
+              summary: 'WAN interface restarted.',
+              description: 'The WAN interface on {{ $labels.instance }} has restarted in the last 15 minutes.',
+            },
+          },
+          {
+            alert: 'HitronCodaWANAddressChanged',
+            expr: |||
+              count by (job, instance) (
+                count_over_time(hitron_coda_cm_dhcp_lease_duration_seconds{%(hitronSelector)s}[1h])
+              ) > 1
+            ||| % $._config,
+            labels: {
+              severity: 'info',
+            },
+            annotations: {
+              summary: 'WAN DHCP lease changed.',
+              description: 'The WAN IP or MAC address of {{ $labels.instance }} has changed in the last hour.',
+            },
+          },
+        ],
+      },
+    ],
+  },
+}
@@ -0,0 +1,28 @@
+{
+  _config+:: {
+    // Selector to apply to all Hitron CODA exporter metrics.
+    hitronSelector: 'job!=""',
+
+    // How long the device must be unreachable before alerting.
+    hitronDownFor: '5m',
+
+    // How long signal levels must be out of spec before alerting.
+    signalOutOfSpecFor: '15m',
+
+    // Downstream power level range, in dBmV. The DOCSIS spec allows -15 to
+    // +15 dBmV, but -7 to +7 dBmV is recommended for 256-QAM.
+    downstreamPowerMinDbmv: -7,
+    downstreamPowerMaxDbmv: 7,
+
+    // Minimum downstream SNR, in dB. 33 dB is the minimum for 256-QAM.
+    downstreamSnrMinDb: 33,
+
+    // Maximum upstream power level, in dBmV. Above this, the modem is
+    // struggling to reach the CMTS.
+    upstreamPowerMaxDbmv: 51,
+
+    // Rate of uncorrectable downstream blocks, per second, above which to
+    // alert.
+    downstreamUncorrectedRateThreshold: 1,
+  },
+}
@@ -1,3 +1,6 @@
+(import 'config.libsonnet') +
+(import 'alerts/alerts.libsonnet') +
+(import 'rules/rules.libsonnet') +
 {
   grafanaDashboards: {
     'cable_modem_metrics.json': (import 'dashboards/cable_modem_metrics.json'),
@@ -0,0 +1,48 @@
+{
+  prometheusRules+:: {
+    groups+: [
+      {
+        name: 'hitron-coda.rules',
+        rules: [
+          {
+            record: 'instance_channel:hitron_coda_cm_downstream_corrected_blocks:rate5m',
+            expr: |||
+              sum by (job, instance, channel) (
+                rate(hitron_coda_cm_downstream_corrected_blocks{%(hitronSelector)s}[5m])
+              )
+            ||| % $._config,
+          },
+          {
+            record: 'instance_channel:hitron_coda_cm_downstream_uncorrected_blocks:rate5m',
+            expr: |||
+              sum by (job, instance, channel) (
+                rate(hitron_coda_cm_downstream_uncorrected_blocks{%(hitronSelector)s}[5m])
+              )
+            ||| % $._config,
+          },
+          {
+            record: 'instance:hitron_coda_cm_downstream_uncorrected_blocks:rate5m',
+            expr: |||
+              sum by (job, instance) (
+                rate(hitron_coda_cm_downstream_uncorrected_blocks{%(hitronSelector)s}[5m])
+              )
+            ||| % $._config,
+          },
+        ] + [
+          {
+            record: 'instance:%s:%s' % [metric, agg],
+            expr: |||
+              %(agg)s by (job, instance) (%(metric)s{%(hitronSelector)s})
+            ||| % ($._config { agg: agg, metric: metric }),
+          }
+          for metric in [
+            'hitron_coda_cm_downstream_signal_strength_dbmv',
+            'hitron_coda_cm_downstream_signal_noise_ratio_db',
+            'hitron_coda_cm_upstream_signal_strength_dbmv',
+          ]
+          for agg in ['min', 'max', 'avg']
+        ],
+      },
+    ],
+  },
+}
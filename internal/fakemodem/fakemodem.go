@@ -0,0 +1,282 @@
+// Package fakemodem implements a fake Hitron CODA cable modem, which serves
+// the device's JSON API from fixtures. It's intended for testing, and for
+// local development without access to a real device.
+package fakemodem
+
+import (
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+const (
+	// apiPrefix is the prefix for all API paths
+	apiPrefix = "/1/Device/"
+
+	loginPath  = apiPrefix + "Users/Login"
+	logoutPath = apiPrefix + "Users/Logout"
+
+	sessionCookie = "sessionindex"
+
+	// DefaultUsername is the username accepted by default
+	DefaultUsername = "cusadmin"
+	// DefaultPassword is the password accepted by default
+	DefaultPassword = "password"
+)
+
+// Fixtures returns the built-in fixtures, modelled on a CODA-4680
+func Fixtures() fs.FS {
+	fsys, _ := fs.Sub(fixtures, "fixtures")
+
+	return fsys
+}
+
+// FixturePath returns the name of the fixture for the given API path (e.g.
+// CM/DsInfo.json for /1/Device/CM/DsInfo), or false if the path isn't an API
+// path with a fixture.
+func FixturePath(urlPath string) (string, bool) {
+	if !strings.HasPrefix(urlPath, apiPrefix) || urlPath == loginPath || urlPath == logoutPath {
+		return "", false
+	}
+
+	name := path.Clean(strings.TrimPrefix(urlPath, apiPrefix))
+	if !fs.ValidPath(name) || name == "." {
+		return "", false
+	}
+
+	return name + ".json", true
+}
+
+// Server is a fake Hitron CODA device. It implements http.Handler.
+//
+// API responses are read from the Fixtures filesystem, named after the API
+// path - for example /1/Device/CM/DsInfo is served from CM/DsInfo.json.
+type Server struct {
+	// Fixtures holds the API responses
+	Fixtures fs.FS
+
+	// Username and Password are the credentials to accept - any credentials
+	// are accepted when both are empty
+	Username string
+	Password string
+
+	// Malformed is a list of API paths (e.g. "CM/DsInfo") which respond with
+	// malformed JSON
+	Malformed []string
+
+	// Latency is added to every response
+	Latency time.Duration
+
+	// FailAuth makes every login attempt fail
+	FailAuth bool
+
+	sessions map[string]bool
+	logins   int
+	mu       sync.Mutex
+}
+
+// New returns a fake device serving the built-in fixtures, which accepts the
+// default credentials.
+func New() *Server {
+	return &Server{
+		Fixtures: Fixtures(),
+		Username: DefaultUsername,
+		Password: DefaultPassword,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	latency := s.Latency
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch {
+	case r.URL.Path == loginPath:
+		s.login(w, r)
+	case r.URL.Path == logoutPath:
+		s.logout(w, r)
+	case strings.HasPrefix(r.URL.Path, apiPrefix):
+		s.api(w, r)
+	default:
+		// the device serves its web UI (including the login page) for
+		// everything else
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(loginPage))
+	}
+}
+
+// Logins returns the number of successful logins so far
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.logins
+}
+
+// ExpireSessions logs out all sessions, as the device does after a period of
+// inactivity
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = nil
+}
+
+// SetLatency sets the latency added to every response
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Latency = d
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	creds := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{}
+
+	if err := json.Unmarshal([]byte(r.PostFormValue("model")), &creds); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid login request")
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	anyCreds := s.Username == "" && s.Password == ""
+
+	if s.FailAuth || (!anyCreds && (creds.Username != s.Username || creds.Password != s.Password)) {
+		writeError(w, http.StatusForbidden, "Login fail")
+
+		return
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	sid := hex.EncodeToString(id)
+
+	if s.sessions == nil {
+		s.sessions = map[string]bool{}
+	}
+
+	s.sessions[sid] = true
+	s.logins++
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: sid, Path: "/", HttpOnly: true})
+	writeJSON(w, http.StatusOK, map[string]string{"errCode": "000", "errMsg": "", "result": "success"})
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		s.mu.Lock()
+		delete(s.sessions, c.Value)
+		s.mu.Unlock()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"errCode": "000", "errMsg": ""})
+}
+
+func (s *Server) authenticated(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sessions[c.Value]
+}
+
+func (s *Server) api(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticated(r) {
+		// like the real device, redirect to the login page
+		http.Redirect(w, r, "/", http.StatusFound)
+
+		return
+	}
+
+	name, ok := FixturePath(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+
+		return
+	}
+
+	s.mu.Lock()
+	malformed := slices.Contains(s.Malformed, strings.TrimSuffix(name, ".json"))
+	s.mu.Unlock()
+
+	if malformed {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"errCode":"000","errMsg":"","Freq_List":[{"portId":`))
+
+		return
+	}
+
+	b, err := fs.ReadFile(s.Fixtures, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "not found")
+
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"errCode": "001", "errMsg": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+const loginPage = `<!DOCTYPE html>
+<html>
+<head><title>Hitron CODA</title></head>
+<body>
+	<form action="/1/Device/Users/Login" method="post">
+		<input type="text" name="username">
+		<input type="password" name="password">
+		<input type="submit" value="Login">
+	</form>
+</body>
+</html>
+`
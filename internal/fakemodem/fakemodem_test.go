@@ -0,0 +1,126 @@
+package fakemodem
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func login(t *testing.T, hc *http.Client, base, username, password string) int {
+	t.Helper()
+
+	model, _ := json.Marshal(map[string]string{"username": username, "password": password})
+
+	resp, err := hc.PostForm(base+loginPath, url.Values{"model": {string(model)}})
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func get(t *testing.T, hc *http.Client, u string) (int, string) {
+	t.Helper()
+
+	resp, err := hc.Get(u)
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(b)
+}
+
+func newClient() *http.Client {
+	jar, _ := cookiejar.New(nil)
+
+	return &http.Client{Jar: jar}
+}
+
+func TestServer(t *testing.T) {
+	s := New()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	hc := newClient()
+
+	// not logged in yet - redirected to the login page
+	code, body := get(t, hc, srv.URL+"/1/Device/CM/DsInfo")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "<html>")
+
+	assert.Equal(t, http.StatusForbidden, login(t, hc, srv.URL, DefaultUsername, "wrong"))
+	assert.Equal(t, http.StatusOK, login(t, hc, srv.URL, DefaultUsername, DefaultPassword))
+	assert.Equal(t, 1, s.Logins())
+
+	code, body = get(t, hc, srv.URL+"/1/Device/CM/DsInfo")
+	assert.Equal(t, http.StatusOK, code)
+
+	expected, err := fs.ReadFile(Fixtures(), "CM/DsInfo.json")
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), body)
+
+	code, _ = get(t, hc, srv.URL+"/1/Device/CM/Bogus")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	s.Malformed = []string{"CM/DsInfo"}
+	_, body = get(t, hc, srv.URL+"/1/Device/CM/DsInfo")
+	assert.Error(t, json.Unmarshal([]byte(body), &map[string]any{}))
+
+	s.ExpireSessions()
+
+	_, body = get(t, hc, srv.URL+"/1/Device/CM/UsInfo")
+	assert.True(t, strings.HasPrefix(body, "<!DOCTYPE html>"))
+
+	s.FailAuth = true
+	assert.Equal(t, http.StatusForbidden, login(t, hc, srv.URL, DefaultUsername, DefaultPassword))
+}
+
+func TestAnyCredentials(t *testing.T) {
+	s := New()
+	s.Username = ""
+	s.Password = ""
+
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	assert.Equal(t, http.StatusOK, login(t, newClient(), srv.URL, "anyone", "anything"))
+}
+
+func TestFixturePath(t *testing.T) {
+	name, ok := FixturePath("/1/Device/CM/DsInfo")
+	assert.True(t, ok)
+	assert.Equal(t, "CM/DsInfo.json", name)
+
+	for _, p := range []string{"/", "/1/Device/", "/1/Device/Users/Login", "/1/Device/../../etc/passwd"} {
+		_, ok = FixturePath(p)
+		assert.False(t, ok, p)
+	}
+}
+
+func TestFixturesAreValidJSON(t *testing.T) {
+	err := fs.WalkDir(Fixtures(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		b, err := fs.ReadFile(Fixtures(), path)
+		require.NoError(t, err)
+
+		assert.True(t, json.Valid(b), "invalid JSON in %s", path)
+
+		return nil
+	})
+	require.NoError(t, err)
+}
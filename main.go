@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 	_ "time/tzdata"
@@ -22,6 +26,14 @@ var (
 		C: &config{},
 	}
 	reloadCh chan chan error
+
+	// scrapeConcurrency is the maximum number of concurrent API calls to a
+	// single device
+	scrapeConcurrency = 4
+
+	// scrapeTimeoutOffset is subtracted from the scrape timeout requested by
+	// Prometheus
+	scrapeTimeoutOffset = 500 * time.Millisecond
 )
 
 func handler(w http.ResponseWriter, r *http.Request) {
@@ -33,44 +45,143 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	conf := *sc.C
 	sc.RUnlock()
 
-	registry := prometheus.NewRegistry()
-	collector := newCollector(r.Context(), conf)
-	registry.MustRegister(collector)
+	q := r.URL.Query()
+
+	target, err := conf.resolveTarget(q.Get("target"), q.Get("auth"), q.Get("module"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if collect := q["collect[]"]; len(collect) > 0 {
+		if err := target.selectCollectors(collect); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	ctx := r.Context()
+
+	if timeout := scrapeTimeout(r, &conf, target); timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var gatherer prometheus.Gatherer
+
+	if p := polls.get(target.Host); p != nil {
+		// serve the results of the last background poll
+		gatherer = p.gatherer()
+	} else {
+		registry := prometheus.NewRegistry()
+		collector := newCollector(ctx, target, scrapeConcurrency)
+		registry.MustRegister(collector)
+
+		gatherer = registry
+	}
 
 	// Delegate http serving to Prometheus client library, which will call collector.Collect.
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	h := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
 	h.ServeHTTP(w, r)
 
 	duration := time.Since(start).Seconds()
 	exporterDurationSummary.Observe(duration)
 	exporterDuration.Observe(duration)
 
-	slog.DebugContext(r.Context(), "Finished scrape", slog.Float64("duration_seconds", duration))
+	slog.DebugContext(r.Context(), "Finished scrape", slog.String("target", target.Host), slog.Float64("duration_seconds", duration))
+}
+
+// scrapeTimeout returns the timeout to use for the scrape, based on the
+// timeout Prometheus sends in the X-Prometheus-Scrape-Timeout-Seconds header,
+// and the configured timeouts. The shortest applicable timeout wins, and 0
+// means no timeout.
+func scrapeTimeout(r *http.Request, conf *config, target *scrapeTarget) time.Duration {
+	var timeout time.Duration
+
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || secs <= 0 {
+			slog.WarnContext(r.Context(), "Ignoring invalid scrape timeout header", "value", v)
+		} else {
+			timeout = time.Duration(secs * float64(time.Second))
+
+			// leave some time to send the response back to Prometheus
+			if timeout > scrapeTimeoutOffset {
+				timeout -= scrapeTimeoutOffset
+			}
+		}
+	}
+
+	for _, t := range []time.Duration{target.Timeout, conf.MaxTimeout} {
+		if t > 0 && (timeout == 0 || t < timeout) {
+			timeout = t
+		}
+	}
+
+	return timeout
 }
 
-func handleHUP(configFile string) {
+// reloadConfig reloads the config file, and restarts the background pollers
+func reloadConfig(configFile string) error {
+	if err := sc.ReloadConfig(configFile); err != nil {
+		configLastReloadSuccessful.Set(0)
+
+		return err
+	}
+
+	sc.RLock()
+	conf := sc.C
+	sc.RUnlock()
+
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+	configInfo.Reset()
+	configInfo.WithLabelValues(conf.redactedHash).Set(1)
+
+	polls.reload(conf)
+
+	if readyMaxPollAge > 0 && len(polls.lastSuccesses()) == 0 {
+		slog.Warn("--ready.max-poll-age is set, but no targets have a poll_interval, so only the config is checked by /-/ready")
+	}
+
+	return nil
+}
+
+func handleHUP(configFile string, ws *webServer) {
 	hup := make(chan os.Signal, 1)
 	signal.Notify(hup, syscall.SIGHUP)
 
 	reloadCh = make(chan chan error)
 
+	// the config file and the web config file are reloaded independently, so
+	// that an error in one doesn't stop the other from being picked up
+	reload := func() error {
+		err := reloadConfig(configFile)
+		if err != nil {
+			slog.Error("Error reloading config", "err", err)
+		} else {
+			slog.Info("Loaded config file")
+		}
+
+		webErr := ws.reload()
+		if webErr != nil {
+			slog.Error("Error reloading web config", "err", webErr)
+		}
+
+		return errors.Join(err, webErr)
+	}
+
 	go func() {
 		for {
 			select {
 			case <-hup:
-				if err := sc.ReloadConfig(configFile); err != nil {
-					slog.Error("Error reloading config", "err", err)
-				} else {
-					slog.Info("Loaded config file")
-				}
+				_ = reload()
 			case rc := <-reloadCh:
-				if err := sc.ReloadConfig(configFile); err != nil {
-					slog.Error("Error reloading config", "err", err)
-					rc <- err
-				} else {
-					slog.Info("Loaded config file")
-					rc <- nil
-				}
+				rc <- reload()
 			}
 		}
 	}()
@@ -83,6 +194,8 @@ func initRoutes() http.Handler {
 	mux.HandleFunc("/scrape", func(w http.ResponseWriter, r *http.Request) {
 		handler(w, r)
 	})
+	mux.HandleFunc("/-/healthy", healthyHandler)
+	mux.HandleFunc("/-/ready", readyHandler)
 	mux.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case "POST":
@@ -99,31 +212,7 @@ func initRoutes() http.Handler {
 		}
 	})
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
-		_, _ = w.Write([]byte(`<html>
-<head>
-	<title>Hitron CODA Cable Modem Exporter</title>
-	<style>
-		label{
-			display:inline-block;
-			width:75px;
-		}
-		form label {
-			margin: 10px;
-		}
-		form input {
-			margin: 10px;
-		}
-	</style>
-</head>
-	<body>
-		<h1>Hitron CODA Cable Modem Exporter</h1>
-		<form action="/scrape">
-			<input type="submit" value="/scrape">
-		</form>
-	</body>
-</html>`))
-	})
+	mux.HandleFunc("/", statusHandler)
 
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -142,7 +231,11 @@ func main() {
 	level := "info"
 	format := "logfmt"
 	configFile := "hitron_coda.yml"
+	autoReloadInterval := time.Duration(0)
 	listenAddress := ":9780"
+	webConfigFile := ""
+	recordDir := ""
+	replayDir := ""
 
 	kingpin.HelpFlag.Short('h')
 	kingpin.Version(version.Version)
@@ -150,18 +243,63 @@ func main() {
 	kingpin.Flag("log.level", "log level (debug, info, warn, error)").Default("info").StringVar(&level)
 	kingpin.Flag("log.format", "log format (logfmt, json)").Default("logfmt").StringVar(&format)
 	kingpin.Flag("config.file", "Path to configuration file.").Default("hitron_coda.yml").StringVar(&configFile)
+	kingpin.Flag("config.auto-reload-interval", "Interval to check the config file for changes, reloading it when it changes. Disabled when 0.").Default("0s").DurationVar(&autoReloadInterval)
 	kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9780").StringVar(&listenAddress)
+	kingpin.Flag("web.config.file", "Path to a web config file, to enable TLS and/or basic auth.").StringVar(&webConfigFile)
+	kingpin.Flag("scrape.concurrency", "Maximum number of concurrent API calls to a single device, shared by all scrapes and polls of the device.").Default("4").IntVar(&scrapeConcurrency)
+	kingpin.Flag("scrape.timeout-offset", "Offset to subtract from the timeout requested by Prometheus.").Default("0.5s").DurationVar(&scrapeTimeoutOffset)
 
-	kingpin.Parse()
+	kingpin.Flag("ready.max-poll-age", "Maximum age of each polled target's last successful poll for /-/ready to report ready. Disabled when 0.").Default("0s").DurationVar(&readyMaxPollAge)
 
-	initExporterMetrics()
+	kingpin.Flag("record.dir", "Directory to record all API responses into (redacted), for sharing in bug reports.").StringVar(&recordDir)
+	kingpin.Flag("replay.dir", "Directory to replay recorded API responses from, instead of scraping real devices.").StringVar(&replayDir)
+
+	kingpin.Command("serve", "Run the exporter.").Default()
+
+	fakeModem := newFakeModemCommand()
+	checkConfig := newCheckConfigCommand()
+
+	cmd := kingpin.Parse()
 
 	initLogger(level, format)
 
+	if cmd == checkConfig.cmd.FullCommand() {
+		if err := checkConfig.run(os.Stdout, configFile, webConfigFile); err != nil {
+			exitCode = 1
+		}
+
+		return
+	}
+
+	if cmd == fakeModem.cmd.FullCommand() {
+		if err := fakeModem.run(); err != nil {
+			slog.Error("Error running fake device", "err", err)
+
+			exitCode = 1
+		}
+
+		return
+	}
+
+	initExporterMetrics()
+
+	switch {
+	case recordDir != "" && replayDir != "":
+		slog.Error("Only one of --record.dir and --replay.dir can be used")
+
+		exitCode = 1
+
+		return
+	case recordDir != "":
+		sessions.resolveHost = newRecorder(recordDir)
+	case replayDir != "":
+		sessions.resolveHost = newReplayer(replayDir)
+	}
+
 	slog.Info("Starting hitron_coda_exporter", "version", version.Version, "commit", version.GitCommit)
 
 	// Bail early if the config is bad.
-	err := sc.ReloadConfig(configFile)
+	err := reloadConfig(configFile)
 	if err != nil {
 		slog.Error("Error parsing config file", "err", err)
 
@@ -170,20 +308,45 @@ func main() {
 		return
 	}
 
-	handleHUP(configFile)
+	ws, err := newWebServer(webConfigFile)
+	if err != nil {
+		slog.Error("Error parsing web config file", "err", err)
+
+		exitCode = 1
+
+		return
+	}
+
+	handleHUP(configFile, ws)
+
+	if autoReloadInterval > 0 {
+		sc.RLock()
+		hash := sc.C.hash
+		sc.RUnlock()
+
+		go watchConfig(context.Background(), configFile, hash, autoReloadInterval)
+	}
 
 	mux := initRoutes()
 
 	slog.Info("Listening on", "address", listenAddress)
 
 	srv := &http.Server{
-		Addr:    listenAddress,
 		Handler: mux,
 		//nolint:gomnd
 		ReadHeaderTimeout: 2 * time.Second,
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
+	l, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		slog.Error("Error starting HTTP server", "err", err)
+
+		exitCode = 1
+
+		return
+	}
+
+	if err := ws.serve(l, srv); err != nil {
 		slog.Error("Error starting HTTP server", "err", err)
 
 		exitCode = 1
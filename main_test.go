@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerRequiresTarget(t *testing.T) {
+	sc.Lock()
+	sc.C = &config{Username: "user", Password: "pass"}
+	sc.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, "/scrape", nil)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "'target' parameter must be specified")
+
+	// unlisted targets aren't scraped with the configured credentials
+	rec = httptest.NewRecorder()
+
+	handler(rec, httptest.NewRequest(http.MethodGet, "/scrape?target=attacker.example&auth=admin", nil))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "target is not configured")
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	conf := &config{}
+	target := &scrapeTarget{}
+
+	req := httptest.NewRequest(http.MethodGet, "/scrape", nil)
+	assert.Equal(t, time.Duration(0), scrapeTimeout(req, conf, target))
+
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "10")
+	assert.Equal(t, 9500*time.Millisecond, scrapeTimeout(req, conf, target))
+
+	// too short to subtract the offset from
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.25")
+	assert.Equal(t, 250*time.Millisecond, scrapeTimeout(req, conf, target))
+
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "bogus")
+	assert.Equal(t, time.Duration(0), scrapeTimeout(req, conf, target))
+
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "10")
+
+	target.Timeout = 5 * time.Second
+	assert.Equal(t, 5*time.Second, scrapeTimeout(req, conf, target))
+
+	conf.MaxTimeout = 2 * time.Second
+	assert.Equal(t, 2*time.Second, scrapeTimeout(req, conf, target))
+
+	req.Header.Del("X-Prometheus-Scrape-Timeout-Seconds")
+	target.Timeout = 0
+	assert.Equal(t, 2*time.Second, scrapeTimeout(req, conf, target))
+}
+
+func TestReloadConfigMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hitron_coda.yml")
+	require.NoError(t, os.WriteFile(path, []byte(minimalConfig), 0o600))
+
+	require.NoError(t, reloadConfig(path))
+	assert.InDelta(t, 1.0, testutil.ToFloat64(configLastReloadSuccessful), 0)
+	assert.InDelta(t, 1.0, testutil.ToFloat64(configInfo.WithLabelValues(sc.C.redactedHash)), 0)
+	assert.Equal(t, 1, testutil.CollectAndCount(configInfo))
+
+	// the published checksum doesn't depend on the password, so it can't be
+	// used to guess it
+	assert.NotEqual(t, configHash([]byte(minimalConfig)), sc.C.redactedHash)
+
+	hash := sc.C.redactedHash
+
+	require.NoError(t, os.WriteFile(path, []byte(strings.Replace(minimalConfig, "password: pass", "password: other", 1)), 0o600))
+	require.NoError(t, reloadConfig(path))
+	assert.Equal(t, hash, sc.C.redactedHash)
+
+	succeeded := testutil.ToFloat64(configLastReloadSuccessTimestamp)
+	assert.InDelta(t, float64(time.Now().Unix()), succeeded, 5)
+
+	// a failed reload keeps the previous config, and its hash
+	require.NoError(t, os.WriteFile(path, []byte("passwrod: oops\n"), 0o600))
+	require.Error(t, reloadConfig(path))
+	assert.InDelta(t, 0.0, testutil.ToFloat64(configLastReloadSuccessful), 0)
+	assert.InDelta(t, succeeded, testutil.ToFloat64(configLastReloadSuccessTimestamp), 0)
+	assert.Equal(t, 1, testutil.CollectAndCount(configInfo))
+}
+
+func TestReloadConfigAndWebConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hitron_coda.yml")
+	webPath := filepath.Join(dir, "web.yml")
+
+	require.NoError(t, os.WriteFile(path, []byte(minimalConfig), 0o600))
+	writeWebConfig(t, webPath, "")
+
+	require.NoError(t, reloadConfig(path))
+
+	ws, err := newWebServer(webPath)
+	require.NoError(t, err)
+
+	handleHUP(path, ws)
+
+	reload := func() error {
+		rc := make(chan error)
+		reloadCh <- rc
+
+		return <-rc
+	}
+
+	// an invalid web config doesn't stop the config file from being reloaded
+	webConfig := ws.config.Load()
+
+	require.NoError(t, os.WriteFile(path, []byte(minimalConfig+"max_timeout: 5s\n"), 0o600))
+	writeWebConfig(t, webPath, "basic_auth_users: [\n")
+	require.ErrorContains(t, reload(), "web config")
+	assert.InDelta(t, 1.0, testutil.ToFloat64(configLastReloadSuccessful), 0)
+	assert.Equal(t, 5*time.Second, sc.C.MaxTimeout)
+	assert.Same(t, webConfig, ws.config.Load())
+
+	// and an invalid config file doesn't stop the web config from being
+	// reloaded
+	require.NoError(t, os.WriteFile(path, []byte("passwrod: oops\n"), 0o600))
+	writeWebConfig(t, webPath, "")
+	require.Error(t, reload())
+	assert.InDelta(t, 0.0, testutil.ToFloat64(configLastReloadSuccessful), 0)
+	assert.NotSame(t, webConfig, ws.config.Load())
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pollManager runs the background pollers for all targets configured with a
+// poll interval.
+type pollManager struct {
+	pollers map[string]*poller
+	mu      sync.RWMutex
+}
+
+var polls = &pollManager{pollers: map[string]*poller{}}
+
+// poller scrapes a single target in the background, on an interval, caching
+// the results from the last successful poll.
+type poller struct {
+	lastSuccess time.Time
+	target      *scrapeTarget
+	cancel      context.CancelFunc
+	families    []*dto.MetricFamily
+
+	interval  time.Duration
+	staleness time.Duration
+
+	mu sync.RWMutex
+}
+
+// reload stops all running pollers, and starts new ones according to the
+// config. Cached results are carried over to the new pollers.
+func (m *pollManager) reload(conf *config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := m.pollers
+	m.pollers = map[string]*poller{}
+
+	for _, p := range old {
+		p.cancel()
+	}
+
+	for host, tc := range conf.Targets {
+		if tc.PollInterval <= 0 {
+			continue
+		}
+
+		target, err := conf.resolveTarget(host, "", "")
+		if err != nil {
+			slog.Error("Error configuring poller", "target", host, "err", err)
+
+			continue
+		}
+
+		p := newPoller(target, tc.PollInterval, tc.PollStaleness)
+
+		if prev, ok := old[host]; ok {
+			p.families, p.lastSuccess = prev.snapshot()
+		}
+
+		m.pollers[host] = p
+
+		slog.Info("Polling target in the background", "target", host, "interval", p.interval)
+	}
+}
+
+// get returns the poller for the given host, or nil if it isn't polled
+func (m *pollManager) get(host string) *poller {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.pollers[host]
+}
+
+// lastSuccesses returns the time of each polled target's last successful
+// poll, keyed by host - the time is zero for targets not yet polled
+func (m *pollManager) lastSuccesses() map[string]time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string]time.Time, len(m.pollers))
+
+	for host, p := range m.pollers {
+		_, out[host] = p.snapshot()
+	}
+
+	return out
+}
+
+func newPoller(target *scrapeTarget, interval, staleness time.Duration) *poller {
+	if staleness <= 0 {
+		//nolint:gomnd
+		staleness = 3 * interval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &poller{target: target, interval: interval, staleness: staleness, cancel: cancel}
+
+	go p.run(ctx)
+
+	return p
+}
+
+func (p *poller) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll the target once, replacing the cached results only if every API call
+// succeeds, so that a failing device isn't reported as polled successfully
+func (p *poller) poll(ctx context.Context) {
+	timeout := p.interval
+	if p.target.Timeout > 0 && p.target.Timeout < timeout {
+		timeout = p.target.Timeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	slog.DebugContext(ctx, "Polling target", "target", p.target.Host)
+
+	registry := prometheus.NewRegistry()
+	collector := newCollector(ctx, p.target, scrapeConcurrency)
+	registry.MustRegister(collector)
+
+	families, err := registry.Gather()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error polling target", "target", p.target.Host, "err", err)
+
+		return
+	}
+
+	if !collector.reachable {
+		slog.WarnContext(ctx, "Target unreachable, keeping previous poll results", "target", p.target.Host)
+
+		return
+	}
+
+	if len(collector.failures) > 0 {
+		slog.WarnContext(ctx, "Some API calls failed, keeping previous poll results", "target", p.target.Host,
+			"collectors", slices.Sorted(maps.Keys(collector.failures)))
+
+		return
+	}
+
+	p.mu.Lock()
+	p.families = families
+	p.lastSuccess = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *poller) snapshot() ([]*dto.MetricFamily, time.Time) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.families, p.lastSuccess
+}
+
+// gatherer returns a Gatherer for the cached results. Results older than the
+// staleness limit are dropped, and the target is reported as down.
+func (p *poller) gatherer() prometheus.Gatherer {
+	families, lastSuccess := p.snapshot()
+
+	registry := prometheus.NewRegistry()
+
+	lastPoll := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Name:      "last_successful_poll_timestamp_seconds",
+		Help:      "Time of the last successful background poll of the device, in seconds since the epoch",
+	})
+	registry.MustRegister(lastPoll)
+
+	if !lastSuccess.IsZero() {
+		lastPoll.Set(float64(lastSuccess.UnixNano()) / 1e9)
+	}
+
+	if lastSuccess.IsZero() || time.Since(lastSuccess) > p.staleness {
+		up := prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNS,
+			Name:      "up",
+			Help:      "Whether the device is reachable (1), or not (0)",
+		})
+		registry.MustRegister(up)
+
+		return registry
+	}
+
+	return prometheus.Gatherers{
+		registry,
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil }),
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollerGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	up := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: metricsNS, Name: "up"})
+	up.Set(1)
+	registry.MustRegister(up)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	p := &poller{staleness: time.Minute}
+
+	// never polled
+	out, err := p.gatherer().Gather()
+	require.NoError(t, err)
+	assert.Len(t, out, 2)
+	assert.Equal(t, "hitron_coda_last_successful_poll_timestamp_seconds", out[0].GetName())
+	assert.InDelta(t, 0.0, out[0].GetMetric()[0].GetGauge().GetValue(), 0)
+	assert.Equal(t, "hitron_coda_up", out[1].GetName())
+	assert.InDelta(t, 0.0, out[1].GetMetric()[0].GetGauge().GetValue(), 0)
+
+	// fresh results are served
+	p.families = families
+	p.lastSuccess = time.Now()
+
+	out, err = p.gatherer().Gather()
+	require.NoError(t, err)
+	assert.Len(t, out, 2)
+	assert.InDelta(t, float64(p.lastSuccess.Unix()), out[0].GetMetric()[0].GetGauge().GetValue(), 1)
+	assert.InDelta(t, 1.0, out[1].GetMetric()[0].GetGauge().GetValue(), 0)
+
+	// stale results are dropped
+	p.lastSuccess = time.Now().Add(-2 * time.Minute)
+
+	out, err = p.gatherer().Gather()
+	require.NoError(t, err)
+	assert.Len(t, out, 2)
+	assert.InDelta(t, 0.0, out[1].GetMetric()[0].GetGauge().GetValue(), 0)
+}
+
+func TestPollKeepsResultsOnFailure(t *testing.T) {
+	fake, conf := newFakeModem(t)
+
+	target, err := conf.resolveTarget("", "", "")
+	require.NoError(t, err)
+
+	p := &poller{target: target, interval: time.Minute}
+
+	p.poll(context.Background())
+
+	families, lastSuccess := p.snapshot()
+	require.NotEmpty(t, families)
+	require.False(t, lastSuccess.IsZero())
+
+	// a partly-failed poll doesn't replace the results
+	fake.Malformed = []string{"CM/DsInfo"}
+
+	p.poll(context.Background())
+
+	out, last := p.snapshot()
+	assert.Equal(t, families, out)
+	assert.Equal(t, lastSuccess, last)
+}
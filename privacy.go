@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hashedIDLength is the number of hex characters kept from a hashed
+// identifier - plenty to avoid collisions within a single household or office
+const hashedIDLength = 16
+
+// hashIdentifier returns a keyed hash (HMAC-SHA256) of an identifier such as a
+// MAC address or hostname, so that it can be used as a label without revealing
+// the identifier itself. Empty identifiers are left empty.
+func hashIdentifier(key []byte, id string) string {
+	if id == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(id))
+
+	return hex.EncodeToString(mac.Sum(nil))[:hashedIDLength]
+}
+
+// randomHashKey returns a random key for hashing identifiers, used when none
+// is configured. The key is generated once per process, so that hashed labels
+// don't change when the config is reloaded.
+var randomHashKey = sync.OnceValue(func() []byte {
+	//nolint:gomnd
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+
+	return key
+})
+
+const (
+	labelKeep = "keep"
+	labelDrop = "drop"
+	labelHash = "hash"
+)
+
+// identityLabels are the labels which identify a WiFi client
+var identityLabels = []string{"hostname", "mac_addr"}
+
+func (w wifiClientsConfig) validate() error {
+	for label, policy := range w.Labels {
+		if !slices.Contains(identityLabels, label) {
+			return fmt.Errorf("unknown label %q", label)
+		}
+
+		switch policy {
+		case labelKeep, labelDrop, labelHash:
+		default:
+			return fmt.Errorf("invalid policy %q for label %q, must be keep, drop, or hash", policy, label)
+		}
+	}
+
+	if w.MaxClients < 0 {
+		return fmt.Errorf("invalid max_clients %d", w.MaxClients)
+	}
+
+	// hostnames can't contain colons, so these must be MAC addresses
+	for _, entry := range w.AllowList {
+		if _, err := net.ParseMAC(entry); err != nil && strings.Contains(entry, ":") {
+			return fmt.Errorf("invalid MAC address %q in allow_list: %w", entry, err)
+		}
+	}
+
+	return nil
+}
+
+// normalizeAllowList rewrites the MAC addresses in the allow list in the same
+// form as the client MAC addresses they're compared to, so that any of the
+// forms accepted by net.ParseMAC can be used. Other entries are hostnames, and
+// are left as they are.
+func (w *wifiClientsConfig) normalizeAllowList() {
+	for i, entry := range w.AllowList {
+		if mac, err := net.ParseMAC(entry); err == nil {
+			w.AllowList[i] = mac.String()
+		}
+	}
+}
+
+// hashes returns whether any label is hashed
+func (w wifiClientsConfig) hashes() bool {
+	for _, policy := range w.Labels {
+		if policy == labelHash {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadNames reads the names file, if configured
+func (w *wifiClientsConfig) loadNames() error {
+	if w.NamesFile == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(w.NamesFile)
+	if err != nil {
+		return fmt.Errorf("failed to read wifi_clients names_file: %w", err)
+	}
+
+	names := map[string]string{}
+	if err := yaml.Unmarshal(b, &names); err != nil {
+		return fmt.Errorf("failed to parse wifi_clients names_file %s: %w", w.NamesFile, err)
+	}
+
+	w.names = make(map[string]string, len(names))
+
+	for addr, name := range names {
+		mac, err := net.ParseMAC(addr)
+		if err != nil {
+			return fmt.Errorf("invalid MAC address %q in wifi_clients names_file %s: %w", addr, w.NamesFile, err)
+		}
+
+		w.names[mac.String()] = name
+	}
+
+	return nil
+}
+
+// identify returns the hostname and MAC address labels for a client, with the
+// friendly name mapping and label policies applied
+func (w wifiClientsConfig) identify(key []byte, hostname string, mac net.HardwareAddr) (string, string) {
+	addr := mac.String()
+
+	if name, ok := w.names[addr]; ok {
+		hostname = name
+	}
+
+	if slices.ContainsFunc(w.AllowList, func(s string) bool {
+		return s == addr || (hostname != "" && s == hostname)
+	}) {
+		return hostname, addr
+	}
+
+	return applyLabelPolicy(w.Labels["hostname"], key, hostname),
+		applyLabelPolicy(w.Labels["mac_addr"], key, addr)
+}
+
+func applyLabelPolicy(policy string, key []byte, v string) string {
+	switch policy {
+	case labelDrop:
+		return ""
+	case labelHash:
+		return hashIdentifier(key, v)
+	default:
+		return v
+	}
+}
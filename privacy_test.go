@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashIdentifier(t *testing.T) {
+	key := []byte("secret")
+
+	h := hashIdentifier(key, "a4:83:e7:00:00:01")
+	assert.Len(t, h, hashedIDLength)
+	assert.Equal(t, h, hashIdentifier(key, "a4:83:e7:00:00:01"))
+	assert.NotEqual(t, h, hashIdentifier(key, "a4:83:e7:00:00:02"))
+	assert.NotEqual(t, h, hashIdentifier([]byte("other"), "a4:83:e7:00:00:01"))
+
+	assert.Empty(t, hashIdentifier(key, ""))
+}
+
+func TestInitHashKey(t *testing.T) {
+	c := &config{}
+	c.initHashKey()
+	assert.Nil(t, c.hashKey)
+
+	c = &config{HashKey: "secret"}
+	c.initHashKey()
+	assert.Equal(t, []byte("secret"), c.hashKey)
+
+	// a random key is used when hashing is enabled without one
+	c = &config{LANHosts: lanHostsConfig{HashIdentifiers: true}}
+	c.initHashKey()
+	assert.Len(t, c.hashKey, 32)
+
+	// and the same random key is used after the config is reloaded, so that
+	// hashed labels don't change
+	c2 := &config{WiFiClients: wifiClientsConfig{Labels: map[string]string{"mac_addr": labelHash}}}
+	c2.initHashKey()
+	assert.Equal(t, c.hashKey, c2.hashKey)
+}
+
+func TestWiFiClientsIdentify(t *testing.T) {
+	key := []byte("secret")
+	laptop, _ := net.ParseMAC("a4:83:e7:00:00:01")
+	phone, _ := net.ParseMAC("3c:22:fb:00:00:03")
+
+	w := wifiClientsConfig{}
+	hostname, mac := w.identify(key, "laptop", laptop)
+	assert.Equal(t, "laptop", hostname)
+	assert.Equal(t, "a4:83:e7:00:00:01", mac)
+
+	w = wifiClientsConfig{
+		Labels:    map[string]string{"hostname": labelDrop, "mac_addr": labelHash},
+		AllowList: []string{"A4-83-E7-00-00-01"},
+		names:     map[string]string{"3c:22:fb:00:00:03": "Dave's phone"},
+	}
+	w.normalizeAllowList()
+
+	// allow-listed clients are unchanged
+	hostname, mac = w.identify(key, "laptop", laptop)
+	assert.Equal(t, "laptop", hostname)
+	assert.Equal(t, "a4:83:e7:00:00:01", mac)
+
+	hostname, mac = w.identify(key, "android-1234", phone)
+	assert.Empty(t, hostname)
+	assert.Equal(t, hashIdentifier(key, "3c:22:fb:00:00:03"), mac)
+
+	// friendly names can be allow-listed too
+	w.AllowList = []string{"Dave's phone"}
+	hostname, mac = w.identify(key, "android-1234", phone)
+	assert.Equal(t, "Dave's phone", hostname)
+	assert.Equal(t, "3c:22:fb:00:00:03", mac)
+}
+
+func TestWiFiClientsConfig(t *testing.T) {
+	names := filepath.Join(t.TempDir(), "names.yaml")
+	require.NoError(t, os.WriteFile(names, []byte("3C-22-FB-00-00-03: Dave's phone\n"), 0o600))
+
+	c, err := parse(strings.NewReader(minimalConfig + `wifi_clients:
+  labels: {mac_addr: hash}
+  max_clients: 10
+  names_file: ` + names))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"3c:22:fb:00:00:03": "Dave's phone"}, c.WiFiClients.names)
+	assert.True(t, c.WiFiClients.hashes())
+	assert.Len(t, c.hashKey, 32)
+
+	_, err = parse(strings.NewReader(minimalConfig + "wifi_clients: {labels: {ssid: drop}}"))
+	assert.Error(t, err)
+
+	_, err = parse(strings.NewReader(minimalConfig + "wifi_clients: {labels: {hostname: scramble}}"))
+	assert.Error(t, err)
+
+	_, err = parse(strings.NewReader(minimalConfig + "wifi_clients: {max_clients: -1}"))
+	assert.Error(t, err)
+
+	_, err = parse(strings.NewReader(minimalConfig + "wifi_clients: {names_file: /nonexistent}"))
+	assert.Error(t, err)
+
+	// MAC addresses in the allow list can be in any form, but hostnames are
+	// kept as they are
+	c, err = parse(strings.NewReader(minimalConfig + "wifi_clients: {allow_list: [A4-83-E7-00-00-01, a483.e700.0002, Laptop-1]}"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a4:83:e7:00:00:01", "a4:83:e7:00:00:02", "Laptop-1"}, c.WiFiClients.AllowList)
+
+	_, err = parse(strings.NewReader(minimalConfig + "wifi_clients: {allow_list: [\"a4:83:e7:00:00\"]}"))
+	assert.ErrorContains(t, err, "allow_list")
+}
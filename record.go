@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hairyhenderson/hitron_coda_exporter/internal/fakemodem"
+	"github.com/hairyhenderson/hitron_coda_exporter/internal/version"
+)
+
+// In record mode, the client talks to each device through a local proxy,
+// which saves every API response into a fixture bundle. In replay mode, the
+// client talks to a fake device serving a previously-recorded bundle instead.
+//
+// Bundles are laid out as the fake device expects, with a directory per target
+// (e.g. <dir>/192.168.0.1/CM/DsInfo.json).
+
+// localServers runs a local HTTP server per target, to stand in for the device
+type localServers struct {
+	newHandler func(host string) (http.Handler, error)
+	addrs      map[string]string
+	mu         sync.Mutex
+}
+
+// resolve returns the address of the local server for the given host,
+// starting one if necessary
+func (l *localServers) resolve(host string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if addr, ok := l.addrs[host]; ok {
+		return addr, nil
+	}
+
+	h, err := l.newHandler(host)
+	if err != nil {
+		return "", err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to listen: %w", err)
+	}
+
+	srv := &http.Server{
+		Handler: h,
+		//nolint:gomnd
+		ReadHeaderTimeout: 2 * time.Second,
+	}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			slog.Error("Error serving local proxy", "target", host, "err", err)
+		}
+	}()
+
+	if l.addrs == nil {
+		l.addrs = map[string]string{}
+	}
+
+	addr := ln.Addr().String()
+	l.addrs[host] = addr
+
+	return addr, nil
+}
+
+// deviceURL returns the base URL of the device, for a target given either as
+// host[:port], or as an http:// or https:// URL
+func deviceURL(host string) (*url.URL, error) {
+	if !strings.Contains(host, "://") {
+		return &url.URL{Scheme: "http", Host: host}, nil
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL %q: %w", host, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	return u, nil
+}
+
+// bundleDir returns the directory for the target's fixtures in the bundle
+func bundleDir(dir, host string) string {
+	// the directory is named for the host and port only, so that a target
+	// given as a URL doesn't produce nested directories
+	if u, err := deviceURL(host); err == nil {
+		host = u.Host
+	}
+
+	// colons aren't valid in file names everywhere
+	return filepath.Join(dir, strings.ReplaceAll(host, ":", "_"))
+}
+
+// newRecorder returns a host resolver which records every API response from
+// the device into dir. MAC addresses are replaced consistently across every
+// response and target in the bundle.
+func newRecorder(dir string) func(host string) (string, error) {
+	macs := &macReplacements{}
+
+	l := &localServers{
+		newHandler: func(host string) (http.Handler, error) {
+			u, err := deviceURL(host)
+			if err != nil {
+				return nil, err
+			}
+
+			target := bundleDir(dir, host)
+
+			if err := writeBundleManifest(target, host); err != nil {
+				return nil, err
+			}
+
+			proxy := httputil.NewSingleHostReverseProxy(u)
+			proxy.ModifyResponse = func(resp *http.Response) error {
+				return recordResponse(target, resp, macs)
+			}
+
+			slog.Info("Recording API responses", "target", host, "dir", target)
+
+			return proxy, nil
+		},
+	}
+
+	return l.resolve
+}
+
+// newReplayer returns a host resolver which serves every target from the
+// fixture bundle in dir. A bundle recorded from a single target can be replayed
+// for any target.
+func newReplayer(dir string) func(host string) (string, error) {
+	l := &localServers{
+		newHandler: func(host string) (http.Handler, error) {
+			target := bundleDir(dir, host)
+
+			if fi, err := os.Stat(target); err != nil || !fi.IsDir() {
+				target = dir
+			}
+
+			fake := fakemodem.New()
+			fake.Fixtures = os.DirFS(target)
+			// accept whatever credentials are configured
+			fake.Username = ""
+			fake.Password = ""
+
+			slog.Info("Replaying API responses", "target", host, "dir", target)
+
+			return fake, nil
+		},
+	}
+
+	return l.resolve
+}
+
+func writeBundleManifest(dir, host string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	b, err := json.MarshalIndent(map[string]string{
+		"target":           host,
+		"exporter_version": version.Version,
+		"recorded_at":      time.Now().UTC().Format(time.RFC3339),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	//nolint:gosec
+	return os.WriteFile(filepath.Join(dir, "bundle.json"), b, 0o644)
+}
+
+// recordResponse saves a redacted copy of the response body into dir. Only
+// successful JSON responses from the API are recorded.
+func recordResponse(dir string, resp *http.Response, macs *macReplacements) error {
+	name, ok := fakemodem.FixturePath(resp.Request.URL.Path)
+	if !ok || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	// the client still needs to read the body
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	redacted, err := redactJSON(body, macs)
+	if err != nil {
+		slog.Warn("Not recording non-JSON response", "path", resp.Request.URL.Path, "err", err)
+
+		return nil
+	}
+
+	fname := filepath.Join(dir, filepath.FromSlash(name))
+
+	if err := os.MkdirAll(filepath.Dir(fname), 0o755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+
+	//nolint:gosec
+	if err := os.WriteFile(fname, redacted, 0o644); err != nil {
+		return fmt.Errorf("failed to record fixture: %w", err)
+	}
+
+	return nil
+}
+
+var (
+	// macRegexp matches MAC addresses written with separators, either as
+	// 00:11:22:33:44:55, 00-11-22-33-44-55, or 0011.2233.4455
+	macRegexp = regexp.MustCompile(`(?i)\b([0-9a-f]{2}([:-][0-9a-f]{2}){5}|[0-9a-f]{4}(\.[0-9a-f]{4}){2})\b`)
+
+	// bareMACRegexp matches MAC addresses without separators (001122334455).
+	// These can't be told apart from other numbers, so they're only replaced
+	// in values of keys matching macKeyRegexp.
+	bareMACRegexp = regexp.MustCompile(`(?i)\b[0-9a-f]{12}\b`)
+	macKeyRegexp  = regexp.MustCompile(`(?i)mac`)
+
+	// values of keys matching this are always redacted
+	sensitiveKeyRegexp = regexp.MustCompile(`(?i)(passw|pwd|pws|psk|passphrase|secret|key|serial)`)
+)
+
+const redacted = "REDACTED"
+
+// redactJSON removes passwords, serial numbers and MAC addresses from a JSON
+// document. MAC addresses are replaced using macs, so that documents redacted
+// with the same replacements always have the same address replaced by the
+// same documentation address (RFC 7042).
+func redactJSON(in []byte, macs *macReplacements) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	v = redactValue("", v, macs)
+
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func redactValue(key string, v any, macs *macReplacements) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, e := range val {
+			val[k] = redactValue(k, e, macs)
+		}
+
+		return val
+	case []any:
+		for i, e := range val {
+			val[i] = redactValue(key, e, macs)
+		}
+
+		return val
+	case string:
+		if key != "" && sensitiveKeyRegexp.MatchString(key) && val != "" {
+			return redacted
+		}
+
+		replace := func(mac string) string {
+			return formatMAC(macs.replacement(mac), mac)
+		}
+
+		val = macRegexp.ReplaceAllStringFunc(val, replace)
+
+		if macKeyRegexp.MatchString(key) {
+			val = bareMACRegexp.ReplaceAllStringFunc(val, replace)
+		}
+
+		return val
+	default:
+		return v
+	}
+}
+
+// macReplacements maps MAC addresses to their replacements, shared by all
+// the responses recorded into a bundle
+type macReplacements struct {
+	macs map[string]string
+	mu   sync.Mutex
+}
+
+// replacement returns the replacement for the given MAC address, as 12 hex
+// digits. The first 256 addresses are replaced by documentation addresses (RFC
+// 7042), and the rest by locally-administered addresses, so that different
+// addresses are never replaced by the same one.
+func (m *macReplacements) replacement(mac string) string {
+	norm := strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.macs == nil {
+		m.macs = map[string]string{}
+	}
+
+	r, ok := m.macs[norm]
+	if !ok {
+		n := len(m.macs)
+
+		//nolint:gomnd
+		if n < 256 {
+			r = fmt.Sprintf("00005e0053%02x", n)
+		} else {
+			r = fmt.Sprintf("02005e%06x", n)
+		}
+
+		m.macs[norm] = r
+	}
+
+	return r
+}
+
+// formatMAC formats the 12 hex digits of a MAC address in the same style as
+// like - colon-separated, dotted, or bare
+func formatMAC(hex, like string) string {
+	switch {
+	case strings.Contains(like, "."):
+		return hex[0:4] + "." + hex[4:8] + "." + hex[8:12]
+	case len(like) == len(hex):
+		return hex
+	default:
+		return hex[0:2] + ":" + hex[2:4] + ":" + hex[4:6] + ":" + hex[6:8] + ":" + hex[8:10] + ":" + hex[10:12]
+	}
+}
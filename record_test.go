@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactJSON(t *testing.T) {
+	in := `{"errCode":"000","SerialNum":"ABC123","deviceId":"84:0B:7C:01:02:03",` +
+		`"Client_List":[{"macAddr":"84-0b-7c-01-02-03","rssi":"-52"},{"macAddr":"a4:83:e7:00:00:01"}],` +
+		`"password":"hunter2","count":42}`
+
+	out, err := redactJSON([]byte(in), &macReplacements{})
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"errCode":"000","SerialNum":"REDACTED","deviceId":"00:00:5e:00:53:00",`+
+		`"Client_List":[{"macAddr":"00:00:5e:00:53:00","rssi":"-52"},{"macAddr":"00:00:5e:00:53:01"}],`+
+		`"password":"REDACTED","count":42}`, string(out))
+
+	_, err = redactJSON([]byte("<html>"), &macReplacements{})
+	assert.Error(t, err)
+
+	// other MAC formats are replaced consistently, but bare numbers are only
+	// treated as MAC addresses in MAC address fields
+	in = `{"rfMac":"840B7C010203","cmMac":"840b.7c01.0203","id":"84:0b:7c:01:02:03","rx":"123456789012"}`
+
+	out, err = redactJSON([]byte(in), &macReplacements{})
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"rfMac":"00005e005300","cmMac":"0000.5e00.5300","id":"00:00:5e:00:53:00","rx":"123456789012"}`, string(out))
+}
+
+func TestReplacementMAC(t *testing.T) {
+	macs := &macReplacements{}
+	seen := map[string]bool{}
+
+	for i := range 1000 {
+		r := macs.replacement(fmt.Sprintf("001122%06x", i))
+		assert.False(t, seen[r], "duplicate replacement %s", r)
+
+		seen[r] = true
+	}
+
+	assert.Equal(t, "00005e005300", macs.replacement("00:11:22:00:00:00"))
+	assert.Equal(t, "02005e000100", macs.replacement("00-11-22-00-01-00"))
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	t.Cleanup(func() { sessions.resolveHost = nil })
+
+	_, conf := newFakeModem(t)
+	dir := t.TempDir()
+
+	sessions.resolveHost = newRecorder(dir)
+
+	recorded := scrape(t, conf, "")
+	assert.Contains(t, recorded, "hitron_coda_up 1\n")
+
+	b, err := os.ReadFile(filepath.Join(bundleDir(dir, conf.Host), "CM", "Version.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"SerialNum": "REDACTED"`)
+	assert.NotContains(t, string(b), "84:0B:7C")
+
+	assert.FileExists(t, filepath.Join(bundleDir(dir, conf.Host), "bundle.json"))
+
+	// MAC addresses are replaced consistently across fixtures - the device's
+	// CM MAC address is in CM/Version, and its RF MAC address is in both
+	// CM/SysInfo and Router/SysInfo
+	field := func(name, key string) string {
+		b, err := os.ReadFile(filepath.Join(bundleDir(dir, conf.Host), filepath.FromSlash(name)))
+		require.NoError(t, err)
+
+		v := map[string]any{}
+		require.NoError(t, json.Unmarshal(b, &v))
+
+		return v[key].(string)
+	}
+
+	deviceID := field("CM/Version.json", "deviceId")
+	cmMAC := field("CM/SysInfo.json", "MacAddr")
+
+	assert.Equal(t, cmMAC, field("Router/SysInfo.json", "RFMac"))
+	assert.NotEqual(t, deviceID, cmMAC)
+	assert.NotContains(t, cmMAC, "84:0b:7c")
+
+	// replay the bundle for a device which doesn't exist
+	sessions.resolveHost = newReplayer(bundleDir(dir, conf.Host))
+	conf.Host = "192.0.2.1"
+
+	replayed := scrape(t, conf, "")
+	assert.Contains(t, replayed, "hitron_coda_up 1\n")
+	assert.Contains(t, replayed, `hitron_coda_cm_downstream_signal_strength_dbmv{channel="9",modulation="256QAM",port="1"} 4.3`)
+	assert.Contains(t, replayed, `serial="REDACTED"`)
+}
+
+func TestBundleDir(t *testing.T) {
+	assert.Equal(t, filepath.Join("out", "192.168.0.1"), bundleDir("out", "192.168.0.1"))
+	assert.Equal(t, filepath.Join("out", "192.168.0.1_8080"), bundleDir("out", "192.168.0.1:8080"))
+	assert.Equal(t, filepath.Join("out", "192.168.0.1_8080"), bundleDir("out", "http://192.168.0.1:8080"))
+	assert.Equal(t, filepath.Join("out", "modem.example.com"), bundleDir("out", "https://modem.example.com"))
+}
+
+func TestDeviceURL(t *testing.T) {
+	u, err := deviceURL("192.168.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://192.168.0.1", u.String())
+
+	u, err = deviceURL("https://192.168.0.1:8443")
+	require.NoError(t, err)
+	assert.Equal(t, "https://192.168.0.1:8443", u.String())
+
+	_, err = deviceURL("ftp://192.168.0.1")
+	assert.Error(t, err)
+}
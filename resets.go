@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resetState tracks the device's uptimes and counters between scrapes, to
+// detect restarts and counter resets. The device's counters are exported as
+// they are, so a restart shows up as a spike in rate() - these make it possible
+// to tell why.
+type resetState struct {
+	// counters holds the last value of each counter, keyed by metric name and
+	// label values
+	counters map[string]counterValue
+	// resets counts the resets of each counter metric
+	resets      map[string]float64
+	lanUptime   time.Duration
+	wanUptime   time.Duration
+	lastRestart time.Time
+	// restarts counts the device's restarts, and wanRestarts counts the
+	// restarts of the WAN interface alone (e.g. DHCP renewals or reconnects)
+	restarts    float64
+	wanRestarts float64
+	// uptimeSeen is set once the uptimes have been observed
+	uptimeSeen bool
+}
+
+// counterValue is the last value of a counter, and when it was seen
+type counterValue struct {
+	seen time.Time
+	v    float64
+}
+
+// counterExpiry is how long a counter's last value is kept for when it isn't
+// seen, for example because its channel is no longer bonded
+const counterExpiry = time.Hour
+
+// observeCounter records a counter's value, counting a reset if it's lower
+// than the last value
+func (s *targetState) observeCounter(metric string, labelValues []string, v float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &s.resets
+
+	if r.counters == nil {
+		r.counters = map[string]counterValue{}
+		r.resets = map[string]float64{}
+	}
+
+	key := metric + "\x00" + strings.Join(labelValues, "\x00")
+
+	if prev, ok := r.counters[key]; ok && v < prev.v {
+		r.resets[metric]++
+	} else if !ok {
+		// so that the reset counter starts from 0
+		r.resets[metric] += 0
+	}
+
+	r.counters[key] = counterValue{v: v, seen: time.Now()}
+}
+
+// prune forgets the counters which haven't been seen since counterExpiry
+// before now
+func (r *resetState) prune(now time.Time) {
+	for key, c := range r.counters {
+		if now.Sub(c.seen) > counterExpiry {
+			delete(r.counters, key)
+		}
+	}
+}
+
+// observeUptimes records the LAN and WAN uptimes. The device has restarted if
+// the LAN uptime (which is the system uptime) has gone backwards, and only the
+// WAN interface has restarted if just the WAN uptime has.
+func (s *targetState) observeUptimes(now time.Time, lan, wan time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &s.resets
+
+	switch {
+	case !r.uptimeSeen:
+	case lan < r.lanUptime:
+		r.restarts++
+		r.lastRestart = now.Add(-lan)
+	case wan < r.wanUptime:
+		r.wanRestarts++
+	}
+
+	r.lanUptime = lan
+	r.wanUptime = wan
+	r.uptimeSeen = true
+}
+
+// resetMetrics reports the restarts and counter resets detected so far
+type resetMetrics struct {
+	restarts      prometheus.Counter
+	wanRestarts   prometheus.Counter
+	lastRestart   prometheus.Gauge
+	counterResets *prometheus.CounterVec
+}
+
+func newResetMetrics() resetMetrics {
+	m := resetMetrics{}
+
+	m.restarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNS,
+		Subsystem: "device",
+		Name:      "restarts_total",
+		Help:      "Number of times the device has been seen to restart, detected by its system (LAN) uptime going backwards",
+	})
+	m.wanRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNS,
+		Subsystem: "device",
+		Name:      "wan_restarts_total",
+		Help:      "Number of times the WAN interface alone has been seen to restart (e.g. reconnect), detected by its uptime going backwards",
+	})
+	m.lastRestart = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNS,
+		Subsystem: "device",
+		Name:      "last_restart_timestamp_seconds",
+		Help:      "Estimated time of the last detected restart, in seconds since the epoch",
+	})
+	m.counterResets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNS,
+		Name:      "counter_resets_total",
+		Help:      "Number of times a counter exported from the device has been seen to decrease",
+	}, []string{"metric"})
+
+	return m
+}
+
+// Describe implements Prometheus.Collector.
+func (m resetMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.restarts.Describe(ch)
+	m.wanRestarts.Describe(ch)
+	m.lastRestart.Describe(ch)
+	m.counterResets.Describe(ch)
+}
+
+func (m resetMetrics) collect(ch chan<- prometheus.Metric, state *targetState) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	r := &state.resets
+
+	if r.uptimeSeen {
+		m.restarts.Add(r.restarts)
+		m.restarts.Collect(ch)
+
+		m.wanRestarts.Add(r.wanRestarts)
+		m.wanRestarts.Collect(ch)
+	}
+
+	if !r.lastRestart.IsZero() {
+		m.lastRestart.Set(float64(r.lastRestart.Unix()))
+		m.lastRestart.Collect(ch)
+	}
+
+	for metric, v := range r.resets {
+		m.counterResets.WithLabelValues(metric).Add(v)
+	}
+
+	m.counterResets.Collect(ch)
+
+	r.prune(time.Now())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveUptimes(t *testing.T) {
+	t0 := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	s := &targetState{}
+
+	s.observeUptimes(t0, time.Hour, time.Hour)
+	assert.True(t, s.resets.uptimeSeen)
+	assert.Zero(t, s.resets.restarts)
+
+	s.observeUptimes(t0.Add(time.Minute), time.Hour+time.Minute, time.Hour+time.Minute)
+	assert.Zero(t, s.resets.restarts)
+	assert.True(t, s.resets.lastRestart.IsZero())
+
+	// the device restarted 30s ago
+	s.observeUptimes(t0.Add(2*time.Minute), 30*time.Second, 20*time.Second)
+	assert.InDelta(t, 1.0, s.resets.restarts, 0)
+	assert.Equal(t, t0.Add(90*time.Second), s.resets.lastRestart)
+
+	assert.Zero(t, s.resets.wanRestarts)
+
+	// only the WAN interface restarted, which isn't a device restart
+	s.observeUptimes(t0.Add(3*time.Minute), 90*time.Second, 10*time.Second)
+	assert.InDelta(t, 1.0, s.resets.restarts, 0)
+	assert.InDelta(t, 1.0, s.resets.wanRestarts, 0)
+	assert.Equal(t, t0.Add(90*time.Second), s.resets.lastRestart)
+}
+
+func TestObserveCounter(t *testing.T) {
+	s := &targetState{}
+
+	s.observeCounter("a", []string{"1"}, 10)
+	s.observeCounter("a", []string{"2"}, 5)
+	assert.Equal(t, map[string]float64{"a": 0}, s.resets.resets)
+
+	s.observeCounter("a", []string{"1"}, 20)
+	s.observeCounter("a", []string{"2"}, 3)
+	s.observeCounter("b", nil, 1)
+	assert.Equal(t, map[string]float64{"a": 1, "b": 0}, s.resets.resets)
+
+	s.observeCounter("a", []string{"1"}, 0)
+	assert.Equal(t, map[string]float64{"a": 2, "b": 0}, s.resets.resets)
+
+	// counters which stop being seen are forgotten
+	s.resets.prune(time.Now())
+	assert.Len(t, s.resets.counters, 3)
+
+	s.resets.prune(time.Now().Add(counterExpiry + time.Minute))
+	assert.Empty(t, s.resets.counters)
+	assert.Equal(t, map[string]float64{"a": 2, "b": 0}, s.resets.resets)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// secret is a string which is redacted when printed, logged, or marshalled,
+// so that passwords can't leak into logs or config dumps by accident
+type secret string
+
+func (s secret) redact() string {
+	if s == "" {
+		return ""
+	}
+
+	return "<secret>"
+}
+
+// String implements fmt.Stringer.
+func (s secret) String() string {
+	return s.redact()
+}
+
+// LogValue implements slog.LogValuer.
+func (s secret) LogValue() slog.Value {
+	return slog.StringValue(s.redact())
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (s secret) MarshalYAML() (interface{}, error) {
+	return s.redact(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler, used when marshalling to JSON.
+func (s secret) MarshalText() ([]byte, error) {
+	return []byte(s.redact()), nil
+}
+
+// passwordCommandTimeout is how long a password_command can run for
+var passwordCommandTimeout = 30 * time.Second
+
+var envRef = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references with the values of the environment
+// variables. Unset variables are an error, rather than silently expanding to
+// an empty string. $${VAR} is an escaped reference, and becomes a literal
+// ${VAR}.
+func expandEnv(s string) (string, error) {
+	var missing []string
+
+	out := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+
+		name := envRef.FindStringSubmatch(ref)[1]
+
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+
+		return v
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	return out, nil
+}
+
+// resolvePassword returns the password from whichever of password,
+// password_file, or password_command is set, with environment variables
+// expanded. The file is re-read (and the command re-run) each time the config
+// is loaded.
+func resolvePassword(password secret, file string, command []string) (secret, error) {
+	if passwordSources(password, file, command) > 1 {
+		return "", errors.New("only one of password, password_file, and password_command can be set")
+	}
+
+	switch {
+	case file != "":
+		return readPasswordFile(file)
+	case len(command) > 0:
+		return runPasswordCommand(command)
+	default:
+		p, err := expandEnv(string(password))
+		if err != nil {
+			return "", fmt.Errorf("password: %w", err)
+		}
+
+		return secret(p), nil
+	}
+}
+
+// passwordSources returns how many of password, password_file, and
+// password_command are set
+func passwordSources(password secret, file string, command []string) int {
+	n := 0
+
+	for _, ok := range []bool{password != "", file != "", len(command) > 0} {
+		if ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+func readPasswordFile(file string) (secret, error) {
+	file, err := expandEnv(file)
+	if err != nil {
+		return "", fmt.Errorf("password_file: %w", err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password_file: %w", err)
+	}
+
+	p := strings.TrimRight(string(b), "\r\n")
+	if p == "" {
+		return "", fmt.Errorf("password_file %s is empty", file)
+	}
+
+	return secret(p), nil
+}
+
+// runPasswordCommand runs the command (without a shell), and returns the
+// first line of its output as the password. The command's output is never
+// included in errors.
+func runPasswordCommand(command []string) (secret, error) {
+	args := make([]string, len(command))
+
+	for i, arg := range command {
+		var err error
+
+		args[i], err = expandEnv(arg)
+		if err != nil {
+			return "", fmt.Errorf("password_command: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), passwordCommandTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+
+	//nolint:gosec
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("password_command %s failed: %w", args[0], err)
+	}
+
+	p, _, _ := strings.Cut(stdout.String(), "\n")
+
+	p = strings.TrimRight(p, "\r")
+	if p == "" {
+		return "", fmt.Errorf("password_command %s printed no password", args[0])
+	}
+
+	return secret(p), nil
+}
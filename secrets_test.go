@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSecretRedacted(t *testing.T) {
+	target := scrapeTarget{Host: "192.168.0.1", Username: "cusadmin", Password: "hunter2"}
+
+	assert.NotContains(t, fmt.Sprintf("%v %+v %s", target, target, target.Password), "hunter2")
+
+	var buf bytes.Buffer
+
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("test", "password", target.Password)
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.Contains(t, buf.String(), "password=<secret>")
+
+	b, err := yaml.Marshal(authConfig{Username: "cusadmin", Password: "hunter2"})
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "hunter2")
+
+	b, err = json.Marshal(authConfig{Username: "cusadmin", Password: "hunter2"})
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "hunter2")
+
+	// unset secrets aren't shown as set
+	assert.Equal(t, "", secret("").String())
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("HITRON_TEST_PASSWORD", "hunter2")
+
+	s, err := expandEnv("${HITRON_TEST_PASSWORD}")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", s)
+
+	s, err = expandEnv("pre-${HITRON_TEST_PASSWORD}-$NOT_EXPANDED")
+	require.NoError(t, err)
+	assert.Equal(t, "pre-hunter2-$NOT_EXPANDED", s)
+
+	_, err = expandEnv("${HITRON_TEST_UNSET}")
+	assert.ErrorContains(t, err, "HITRON_TEST_UNSET")
+
+	// escaped references are kept literally, even when the variable is unset
+	s, err = expandEnv("pa$${HITRON_TEST_UNSET}ss-$${HITRON_TEST_PASSWORD}")
+	require.NoError(t, err)
+	assert.Equal(t, "pa${HITRON_TEST_UNSET}ss-${HITRON_TEST_PASSWORD}", s)
+}
+
+func TestResolvePassword(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(file, []byte("hunter2\n"), 0o600))
+
+	t.Setenv("HITRON_TEST_DIR", dir)
+
+	p, err := resolvePassword("plain", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, secret("plain"), p)
+
+	p, err = resolvePassword("", "${HITRON_TEST_DIR}/password", nil)
+	require.NoError(t, err)
+	assert.Equal(t, secret("hunter2"), p)
+
+	p, err = resolvePassword("", "", []string{"echo", "from-command"})
+	require.NoError(t, err)
+	assert.Equal(t, secret("from-command"), p)
+
+	_, err = resolvePassword("plain", file, nil)
+	assert.Error(t, err)
+
+	_, err = resolvePassword("", filepath.Join(dir, "missing"), nil)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(file, []byte("\n"), 0o600))
+	_, err = resolvePassword("", file, nil)
+	assert.Error(t, err)
+
+	// the command's output isn't included in the error
+	_, err = resolvePassword("", "", []string{"sh", "-c", "echo hunter2; exit 1"})
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "hunter2")
+}
+
+func TestParseSecrets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(file, []byte("from-file\n"), 0o600))
+
+	t.Setenv("HITRON_TEST_USER", "cusadmin")
+	t.Setenv("HITRON_TEST_PASSWORD", "from-env")
+
+	in := `host: 192.168.0.1
+username: ${HITRON_TEST_USER}
+password: ${HITRON_TEST_PASSWORD}
+auths:
+  admin:
+    username: admin
+    password_file: ` + file + `
+`
+	c, err := parse(strings.NewReader(in))
+	require.NoError(t, err)
+	assert.Equal(t, "cusadmin", c.Username)
+	assert.Equal(t, secret("from-env"), c.Password)
+	assert.Equal(t, secret("from-file"), c.Auths["admin"].Password)
+
+	// the file is re-read when the config is reloaded
+	require.NoError(t, os.WriteFile(file, []byte("rotated\n"), 0o600))
+
+	c, err = parse(strings.NewReader(in))
+	require.NoError(t, err)
+	assert.Equal(t, secret("rotated"), c.Auths["admin"].Password)
+
+	_, err = parse(strings.NewReader("host: 192.168.0.1\nusername: user\npassword: x\npassword_file: " + file))
+	assert.Error(t, err)
+
+	_, err = parse(strings.NewReader(minimalConfig + "auths: {admin: {username: admin, password: '${HITRON_TEST_UNSET}'}}"))
+	assert.ErrorContains(t, err, "auths[admin]")
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	hitron "github.com/hairyhenderson/hitron_coda"
+)
+
+// sessionManager keeps logged-in clients alive between scrapes, so that the
+// device isn't logged in to (and out of) on every scrape.
+type sessionManager struct {
+	sessions map[string]*session
+	// resolveHost optionally maps a target to a different address to connect
+	// to, for recording and replaying API responses
+	resolveHost func(host string) (string, error)
+	mu          sync.Mutex
+}
+
+var sessions = &sessionManager{sessions: map[string]*session{}}
+
+// sessionIdleTimeout is how long a session can go unused before it's logged
+// out and forgotten, for example after its target is removed from the config
+var sessionIdleTimeout = 15 * time.Minute
+
+// logoutTimeout limits how long logging out of a discarded session can take
+const logoutTimeout = 10 * time.Second
+
+// session is a (possibly not yet logged-in) client for a single target
+type session struct {
+	client *hitron.CableModem
+	// pending is the login in progress, if any - concurrent callers wait for
+	// it rather than logging in again
+	pending     *loginAttempt
+	loginTime   time.Time
+	resolveHost func(host string) (string, error)
+
+	// lastUsed is guarded by the sessionManager's lock, not mu, so that a
+	// slow login doesn't block getting other sessions
+	lastUsed time.Time
+
+	host     string
+	username string
+	password secret
+
+	mu sync.Mutex
+}
+
+// loginAttempt is a login in progress, shared by all the callers which need
+// the session while it's logging in
+type loginAttempt struct {
+	done   chan struct{}
+	client *hitron.CableModem
+	err    error
+}
+
+// get returns the session for the given target, creating a new one if there
+// isn't one yet, or if the credentials have changed. Replaced and idle
+// sessions are logged out in the background.
+func (m *sessionManager) get(t *scrapeTarget) *session {
+	key := t.Host + "\x00" + t.Username
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.evictIdle(now)
+
+	s, ok := m.sessions[key]
+	if !ok || s.password != t.Password {
+		if ok {
+			go s.logout()
+		}
+
+		s = &session{host: t.Host, username: t.Username, password: t.Password, resolveHost: m.resolveHost}
+		m.sessions[key] = s
+	}
+
+	s.lastUsed = now
+
+	return s
+}
+
+// evictIdle logs out and forgets the sessions which haven't been used within
+// sessionIdleTimeout. Must be called with the lock held.
+func (m *sessionManager) evictIdle(now time.Time) {
+	for key, s := range m.sessions {
+		if now.Sub(s.lastUsed) <= sessionIdleTimeout {
+			continue
+		}
+
+		delete(m.sessions, key)
+
+		go s.logout()
+	}
+}
+
+// logout logs out of the device, if logged in. Errors are only logged, as the
+// session is being discarded anyway.
+func (s *session) logout() {
+	s.mu.Lock()
+	client := s.client
+	s.client = nil
+	s.mu.Unlock()
+
+	if client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), logoutTimeout)
+	defer cancel()
+
+	if err := client.Logout(ctx); err != nil {
+		slog.DebugContext(ctx, "Error logging out", "target", s.host, "err", err)
+	}
+}
+
+// login returns a logged-in client, logging in first if necessary. Only one
+// login runs at a time, and the session's lock isn't held while it runs, so a
+// slow or unresponsive device only holds up its own callers.
+func (s *session) login(ctx context.Context) (*hitron.CableModem, error) {
+	s.mu.Lock()
+
+	if s.client != nil {
+		client := s.client
+		s.mu.Unlock()
+
+		return client, nil
+	}
+
+	a := s.pending
+	if a == nil {
+		a = &loginAttempt{done: make(chan struct{})}
+		s.pending = a
+
+		go s.runLogin(ctx, a)
+	}
+
+	s.mu.Unlock()
+
+	select {
+	case <-a.done:
+		return a.client, a.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to log in: %w", ctx.Err())
+	}
+}
+
+// runLogin logs in, and records the result in the session and the attempt
+func (s *session) runLogin(ctx context.Context, a *loginAttempt) {
+	a.client, a.err = s.newClient(ctx)
+
+	s.mu.Lock()
+	s.pending = nil
+
+	if a.err == nil {
+		s.client = a.client
+		s.loginTime = time.Now()
+	}
+	s.mu.Unlock()
+
+	close(a.done)
+}
+
+// newClient creates a client for the session's target, and logs in
+func (s *session) newClient(ctx context.Context) (*hitron.CableModem, error) {
+	addr := s.host
+
+	if s.resolveHost != nil {
+		var err error
+
+		addr, err = s.resolveHost(s.host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", s.host, err)
+		}
+	}
+
+	client, err := hitron.New(addr, s.username, string(s.password))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	exporterLogins.Inc()
+
+	err = client.Login(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log in: %w", err)
+	}
+
+	slog.DebugContext(ctx, "Logged in", "target", s.host)
+
+	return client, nil
+}
+
+// invalidate forgets the given client, so that the next call to login will
+// log in again. Nothing happens if the session has already moved on to a
+// different client.
+func (s *session) invalidate(client *hitron.CableModem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == client {
+		s.client = nil
+	}
+}
+
+// age returns how long ago the session was logged in, or 0 if it isn't.
+func (s *session) age() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return 0
+	}
+
+	return time.Since(s.loginTime)
+}
+
+// call fn with a logged-in client. If the call fails because the session has
+// expired, log in again and retry once.
+func call[T any](ctx context.Context, s *session, fn func(*hitron.CableModem, context.Context) (T, error)) (T, error) {
+	client, err := s.login(ctx)
+	if err != nil {
+		var out T
+
+		return out, err
+	}
+
+	out, err := fn(client, ctx)
+	if err == nil || !isAuthError(err) {
+		return out, err
+	}
+
+	slog.DebugContext(ctx, "Session expired, logging in again", "target", s.host, "err", err)
+	s.invalidate(client)
+
+	client, err = s.login(ctx)
+	if err != nil {
+		return out, err
+	}
+
+	return fn(client, ctx)
+}
+
+// isAuthError returns whether err is the result of an expired or otherwise
+// unauthorized session.
+func isAuthError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// the device redirects to the (HTML) login page when the session has
+	// expired, which fails to parse as JSON at the very first character -
+	// other malformed responses aren't auth errors. The client doesn't report
+	// HTTP statuses in a way that can be checked, so nothing else is.
+	var synErr *json.SyntaxError
+
+	return errors.As(err, &synErr) && synErr.Offset == 1 && strings.Contains(synErr.Error(), "'<'")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAuthError(t *testing.T) {
+	fake, conf := newFakeModem(t)
+	fake.Malformed = []string{"CM/DsInfo"}
+
+	ctx := context.Background()
+
+	s := &session{host: conf.Host, username: conf.Username, password: conf.Password}
+	client, err := s.login(ctx)
+	require.NoError(t, err)
+
+	_, err = client.CMVersion(ctx)
+	require.NoError(t, err)
+
+	// a malformed response isn't an auth error
+	_, err = client.CMDsInfo(ctx)
+	require.Error(t, err)
+	assert.False(t, isAuthError(err))
+
+	// the device redirects to the login page once the session expires
+	fake.ExpireSessions()
+
+	_, err = client.CMVersion(ctx)
+	require.Error(t, err)
+	assert.True(t, isAuthError(err))
+
+	assert.False(t, isAuthError(fmt.Errorf("failed to get CMVersion: %w", context.DeadlineExceeded)))
+}
+
+func TestSessionManagerGet(t *testing.T) {
+	m := &sessionManager{sessions: map[string]*session{}}
+	target := &scrapeTarget{Host: "192.168.0.1", Username: "user", Password: "pass"}
+
+	s := m.get(target)
+	assert.Same(t, s, m.get(target))
+
+	// a different user gets a different session
+	assert.NotSame(t, s, m.get(&scrapeTarget{Host: "192.168.0.1", Username: "other", Password: "pass"}))
+
+	// a changed password replaces the session
+	s2 := m.get(&scrapeTarget{Host: "192.168.0.1", Username: "user", Password: "new"})
+	assert.NotSame(t, s, s2)
+	assert.Same(t, s2, m.get(&scrapeTarget{Host: "192.168.0.1", Username: "user", Password: "new"}))
+}
+
+func TestSessionManagerEvictsIdle(t *testing.T) {
+	m := &sessionManager{sessions: map[string]*session{}}
+
+	s := m.get(&scrapeTarget{Host: "192.168.0.1", Username: "user", Password: "pass"})
+	m.get(&scrapeTarget{Host: "192.168.100.1", Username: "user", Password: "pass"})
+
+	m.mu.Lock()
+	s.lastUsed = time.Now().Add(-2 * sessionIdleTimeout)
+	m.mu.Unlock()
+
+	m.get(&scrapeTarget{Host: "192.168.100.1", Username: "user", Password: "pass"})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assert.Len(t, m.sessions, 1)
+	assert.NotContains(t, m.sessions, "192.168.0.1\x00user")
+}
+
+func TestSlowLoginDoesNotBlockOtherTargets(t *testing.T) {
+	// a device which never responds, until the test ends
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	_, conf := newFakeModem(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	slowTarget := &scrapeTarget{Host: strings.TrimPrefix(slow.URL, "http://"), Username: "user", Password: "pass"}
+	s := sessions.get(slowTarget)
+
+	loggedIn := make(chan error, 1)
+
+	go func() {
+		_, err := s.login(ctx)
+		loggedIn <- err
+	}()
+
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return s.pending != nil
+	}, time.Second, time.Millisecond)
+
+	// other targets can still be scraped while the login is stuck
+	body := scrape(t, conf, "")
+	assert.Contains(t, body, "hitron_coda_up 1\n")
+
+	// and other callers waiting for the stuck login give up at their own
+	// deadline
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer waitCancel()
+
+	_, err := sessions.get(slowTarget).login(waitCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	cancel()
+	require.Error(t, <-loggedIn)
+}
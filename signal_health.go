@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	hitron "github.com/hairyhenderson/hitron_coda"
+)
+
+// healthState is the result of checking a signal level against its thresholds
+type healthState int
+
+const (
+	healthOK healthState = iota
+	healthWarn
+	healthCritical
+)
+
+func (h healthState) String() string {
+	switch h {
+	case healthOK:
+		return "ok"
+	case healthWarn:
+		return "warn"
+	case healthCritical:
+		return "critical"
+	default:
+		return fmt.Sprintf("healthState(%d)", int(h))
+	}
+}
+
+const (
+	directionDownstream = "downstream"
+	directionUpstream   = "upstream"
+
+	// defaultModulation is the key for thresholds used for channels with
+	// modulations not in the table
+	defaultModulation = "default"
+)
+
+// signalRange is the acceptable range for a signal level. Levels outside of
+// Min/Max are a warning, and levels outside of CriticalMin/CriticalMax are
+// critical. Unset bounds aren't checked.
+type signalRange struct {
+	Min         *float64
+	Max         *float64
+	CriticalMin *float64 `yaml:"critical_min"`
+	CriticalMax *float64 `yaml:"critical_max"`
+}
+
+// signalThresholds holds the acceptable signal levels for a channel
+type signalThresholds struct {
+	// Power is the acceptable power level, in dBmV
+	Power signalRange
+	// SNR is the acceptable signal-to-noise ratio, in dB
+	SNR signalRange
+}
+
+// thresholdTable holds signal thresholds, keyed by direction (downstream or
+// upstream) and then by modulation (e.g. 256QAM)
+type thresholdTable map[string]map[string]signalThresholds
+
+// channelCheck is the result of a single check of a single channel
+type channelCheck struct {
+	channel string
+	check   string
+	state   healthState
+}
+
+func f64(v float64) *float64 {
+	return &v
+}
+
+// defaultSignalThresholds are based on commonly-cited guidance for DOCSIS 3.0
+// and 3.1 devices. Thresholds in the config override these per modulation.
+//
+//nolint:gomnd
+var defaultSignalThresholds = thresholdTable{
+	directionDownstream: {
+		"64QAM": {
+			Power: signalRange{Min: f64(-10), Max: f64(10), CriticalMin: f64(-15), CriticalMax: f64(15)},
+			SNR:   signalRange{Min: f64(27), CriticalMin: f64(23.5)},
+		},
+		"256QAM": {
+			Power: signalRange{Min: f64(-7), Max: f64(7), CriticalMin: f64(-15), CriticalMax: f64(15)},
+			SNR:   signalRange{Min: f64(33), CriticalMin: f64(30)},
+		},
+		defaultModulation: {
+			Power: signalRange{Min: f64(-7), Max: f64(7), CriticalMin: f64(-15), CriticalMax: f64(15)},
+			SNR:   signalRange{Min: f64(33), CriticalMin: f64(30)},
+		},
+	},
+	directionUpstream: {
+		"QPSK": {
+			Power: signalRange{Min: f64(35), Max: f64(55), CriticalMin: f64(30), CriticalMax: f64(58)},
+		},
+		"16QAM": {
+			Power: signalRange{Min: f64(35), Max: f64(52), CriticalMin: f64(30), CriticalMax: f64(55)},
+		},
+		"64QAM": {
+			Power: signalRange{Min: f64(35), Max: f64(49), CriticalMin: f64(30), CriticalMax: f64(51)},
+		},
+		defaultModulation: {
+			Power: signalRange{Min: f64(35), Max: f64(49), CriticalMin: f64(30), CriticalMax: f64(51)},
+		},
+	},
+}
+
+// evaluate checks v against the range
+func (r signalRange) evaluate(v float64) healthState {
+	switch {
+	case r.CriticalMin != nil && v < *r.CriticalMin,
+		r.CriticalMax != nil && v > *r.CriticalMax:
+		return healthCritical
+	case r.Min != nil && v < *r.Min,
+		r.Max != nil && v > *r.Max:
+		return healthWarn
+	default:
+		return healthOK
+	}
+}
+
+// isSet returns whether any bound is set
+func (r signalRange) isSet() bool {
+	return r.Min != nil || r.Max != nil || r.CriticalMin != nil || r.CriticalMax != nil
+}
+
+func (r signalRange) validate() error {
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return fmt.Errorf("min %g is greater than max %g", *r.Min, *r.Max)
+	}
+
+	if r.CriticalMin != nil && r.CriticalMax != nil && *r.CriticalMin > *r.CriticalMax {
+		return fmt.Errorf("critical_min %g is greater than critical_max %g", *r.CriticalMin, *r.CriticalMax)
+	}
+
+	return nil
+}
+
+func (t thresholdTable) validate() error {
+	for direction, mods := range t {
+		if direction != directionDownstream && direction != directionUpstream {
+			return fmt.Errorf("unknown direction %q", direction)
+		}
+
+		for mod, th := range mods {
+			if err := th.Power.validate(); err != nil {
+				return fmt.Errorf("%s %s power: %w", direction, mod, err)
+			}
+
+			if err := th.SNR.validate(); err != nil {
+				return fmt.Errorf("%s %s snr: %w", direction, mod, err)
+			}
+
+			// the device doesn't report upstream SNR
+			if direction == directionUpstream && th.SNR.isSet() {
+				return fmt.Errorf("%s %s: snr can't be checked for upstream channels", direction, mod)
+			}
+		}
+	}
+
+	return nil
+}
+
+// merge returns a copy of the range, with the bounds set in o replacing those
+// in r
+func (r signalRange) merge(o signalRange) signalRange {
+	if o.Min != nil {
+		r.Min = o.Min
+	}
+
+	if o.Max != nil {
+		r.Max = o.Max
+	}
+
+	if o.CriticalMin != nil {
+		r.CriticalMin = o.CriticalMin
+	}
+
+	if o.CriticalMax != nil {
+		r.CriticalMax = o.CriticalMax
+	}
+
+	return r
+}
+
+// merge returns a copy of the table, with the thresholds in overrides
+// replacing those in t. Each bound is overridden separately, so overriding a
+// modulation's power range keeps its SNR range, and unset bounds keep their
+// values. Modulations which aren't in t start from the direction's default
+// thresholds.
+func (t thresholdTable) merge(overrides thresholdTable) thresholdTable {
+	out := make(thresholdTable, len(t))
+
+	for direction, mods := range t {
+		out[direction] = make(map[string]signalThresholds, len(mods))
+
+		for mod, th := range mods {
+			out[direction][strings.ToUpper(mod)] = th
+		}
+	}
+
+	defaultKey := strings.ToUpper(defaultModulation)
+
+	for direction, mods := range overrides {
+		if out[direction] == nil {
+			out[direction] = map[string]signalThresholds{}
+		}
+
+		// the default goes first, so that new modulations start from the
+		// overridden default
+		keys := slices.Collect(maps.Keys(mods))
+		slices.SortFunc(keys, func(a, b string) int {
+			switch {
+			case strings.EqualFold(a, defaultModulation):
+				return -1
+			case strings.EqualFold(b, defaultModulation):
+				return 1
+			default:
+				return strings.Compare(a, b)
+			}
+		})
+
+		for _, mod := range keys {
+			key := strings.ToUpper(mod)
+
+			base, ok := out[direction][key]
+			if !ok {
+				base = out[direction][defaultKey]
+			}
+
+			out[direction][key] = signalThresholds{
+				Power: base.Power.merge(mods[mod].Power),
+				SNR:   base.SNR.merge(mods[mod].SNR),
+			}
+		}
+	}
+
+	return out
+}
+
+// lookup returns the thresholds for the given direction and modulation,
+// falling back to the default thresholds for the direction
+func (t thresholdTable) lookup(direction, modulation string) (signalThresholds, bool) {
+	mods := t[direction]
+
+	th, ok := mods[strings.ToUpper(modulation)]
+	if !ok {
+		th, ok = mods[strings.ToUpper(defaultModulation)]
+	}
+
+	return th, ok
+}
+
+// evaluate checks each port's signal levels against the thresholds
+func (t thresholdTable) evaluate(direction string, ports []hitron.PortInfo) []channelCheck {
+	checks := []channelCheck{}
+
+	for _, port := range ports {
+		th, ok := t.lookup(direction, port.Modulation)
+		if !ok {
+			continue
+		}
+
+		if th.Power.isSet() {
+			checks = append(checks, channelCheck{
+				channel: port.ChannelID,
+				check:   "power",
+				state:   th.Power.evaluate(port.SignalStrength),
+			})
+		}
+
+		if th.SNR.isSet() {
+			checks = append(checks, channelCheck{
+				channel: port.ChannelID,
+				check:   "snr",
+				state:   th.SNR.evaluate(port.SNR),
+			})
+		}
+	}
+
+	return checks
+}
+
+// worstHealth returns the worst state of all the checks
+func worstHealth(checks []channelCheck) healthState {
+	worst := healthOK
+
+	for _, c := range checks {
+		worst = max(worst, c.state)
+	}
+
+	return worst
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	hitron "github.com/hairyhenderson/hitron_coda"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignalRangeEvaluate(t *testing.T) {
+	r := signalRange{Min: f64(-7), Max: f64(7), CriticalMin: f64(-15), CriticalMax: f64(15)}
+
+	assert.Equal(t, healthOK, r.evaluate(0))
+	assert.Equal(t, healthOK, r.evaluate(7))
+	assert.Equal(t, healthWarn, r.evaluate(7.1))
+	assert.Equal(t, healthWarn, r.evaluate(-10))
+	assert.Equal(t, healthCritical, r.evaluate(15.5))
+	assert.Equal(t, healthCritical, r.evaluate(-16))
+
+	// unset bounds aren't checked
+	r = signalRange{Min: f64(33), CriticalMin: f64(30)}
+	assert.Equal(t, healthOK, r.evaluate(100))
+	assert.Equal(t, healthWarn, r.evaluate(31))
+	assert.Equal(t, healthCritical, r.evaluate(29))
+
+	assert.Equal(t, healthOK, signalRange{}.evaluate(-100))
+}
+
+func TestThresholdTableEvaluate(t *testing.T) {
+	table := defaultSignalThresholds.merge(nil)
+
+	ports := []hitron.PortInfo{
+		{ChannelID: "1", Modulation: "256QAM", SignalStrength: 4.3, SNR: 40},
+		{ChannelID: "2", Modulation: "256QAM", SignalStrength: 8, SNR: 31},
+		// 64QAM allows a wider range
+		{ChannelID: "3", Modulation: "64QAM", SignalStrength: 8, SNR: 31},
+		// unknown modulations use the default thresholds
+		{ChannelID: "4", Modulation: "OFDM", SignalStrength: -16, SNR: 40},
+	}
+
+	checks := table.evaluate(directionDownstream, ports)
+	assert.Equal(t, []channelCheck{
+		{channel: "1", check: "power", state: healthOK},
+		{channel: "1", check: "snr", state: healthOK},
+		{channel: "2", check: "power", state: healthWarn},
+		{channel: "2", check: "snr", state: healthWarn},
+		{channel: "3", check: "power", state: healthOK},
+		{channel: "3", check: "snr", state: healthOK},
+		{channel: "4", check: "power", state: healthCritical},
+		{channel: "4", check: "snr", state: healthOK},
+	}, checks)
+	assert.Equal(t, healthCritical, worstHealth(checks))
+
+	// upstream SNR isn't reported, so isn't checked
+	checks = table.evaluate(directionUpstream, []hitron.PortInfo{
+		{ChannelID: "3", Modulation: "64QAM", SignalStrength: 50},
+	})
+	assert.Equal(t, []channelCheck{{channel: "3", check: "power", state: healthWarn}}, checks)
+
+	assert.Equal(t, healthOK, worstHealth(nil))
+}
+
+func TestThresholdTableOverrides(t *testing.T) {
+	c, err := parse(strings.NewReader(minimalConfig + `signal_thresholds:
+  downstream:
+    256qam:
+      power: {min: -5, max: 5}
+`))
+	require.NoError(t, err)
+
+	table := defaultSignalThresholds.merge(c.SignalThresholds)
+
+	// only the overridden bounds change
+	th, ok := table.lookup(directionDownstream, "256QAM")
+	require.True(t, ok)
+	assert.Equal(t, signalThresholds{
+		Power: signalRange{Min: f64(-5), Max: f64(5), CriticalMin: f64(-15), CriticalMax: f64(15)},
+		SNR:   defaultSignalThresholds[directionDownstream]["256QAM"].SNR,
+	}, th)
+
+	// other modulations are unaffected
+	th, ok = table.lookup(directionDownstream, "64QAM")
+	require.True(t, ok)
+	assert.Equal(t, defaultSignalThresholds[directionDownstream]["64QAM"], th)
+
+	// the defaults aren't modified
+	assert.Equal(t, f64(-7), defaultSignalThresholds[directionDownstream]["256QAM"].Power.Min)
+
+	// new modulations start from the (overridden) default
+	c, err = parse(strings.NewReader(minimalConfig + `signal_thresholds:
+  downstream:
+    1024QAM:
+      snr: {min: 40}
+    default:
+      power: {max: 6}
+`))
+	require.NoError(t, err)
+
+	th, ok = defaultSignalThresholds.merge(c.SignalThresholds).lookup(directionDownstream, "1024QAM")
+	require.True(t, ok)
+	assert.Equal(t, signalThresholds{
+		Power: signalRange{Min: f64(-7), Max: f64(6), CriticalMin: f64(-15), CriticalMax: f64(15)},
+		SNR:   signalRange{Min: f64(40), CriticalMin: f64(30)},
+	}, th)
+
+	// the merged range must make sense
+	_, err = parse(strings.NewReader(minimalConfig + "signal_thresholds: {downstream: {256QAM: {power: {min: 10}}}}"))
+	assert.ErrorContains(t, err, "min 10 is greater than max 7")
+
+	_, err = parse(strings.NewReader(minimalConfig + "signal_thresholds: {sideways: {}}"))
+	assert.Error(t, err)
+
+	_, err = parse(strings.NewReader(minimalConfig + "signal_thresholds: {upstream: {64QAM: {snr: {min: 30}}}}"))
+	assert.Error(t, err)
+
+	_, err = parse(strings.NewReader(minimalConfig + "signal_thresholds: {downstream: {64QAM: {power: {min: 5, max: -5}}}}"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+)
+
+// stateStore holds state which needs to persist between scrapes, such as
+// counters derived from the device's logs, keyed by target host.
+type stateStore struct {
+	states map[string]*targetState
+	mu     sync.Mutex
+}
+
+var states = &stateStore{states: map[string]*targetState{}}
+
+// targetState is the state kept for a single target. Fields must only be
+// accessed while holding the lock.
+type targetState struct {
+	log      logState
+	channels channelState
+	wifi     wifiState
+	resets   resetState
+	status   targetStatus
+	// sem limits the number of concurrent API calls to the target
+	sem chan struct{}
+	mu  sync.Mutex
+}
+
+// get returns the state for the given host, creating it if necessary
+func (s *stateStore) get(host string) *targetState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[host]
+	if !ok {
+		st = &targetState{}
+		s.states[host] = st
+	}
+
+	return st
+}
+
+// semaphore returns the channel limiting the number of concurrent API calls to
+// the target, shared by all of its scrapes and polls
+func (s *targetState) semaphore(concurrency int) chan struct{} {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sem == nil || cap(s.sem) != concurrency {
+		s.sem = make(chan struct{}, concurrency)
+	}
+
+	return s.sem
+}
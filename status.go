@@ -0,0 +1,298 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	hitron "github.com/hairyhenderson/hitron_coda"
+	"gopkg.in/yaml.v3"
+)
+
+// targetStatus summarises the last scrape of a target, for the status page.
+// The channel, OFDM, and client tables are from the last scrape which
+// collected them, so they're kept when a scrape fails or skips a collector.
+type targetStatus struct {
+	LastScrape time.Time
+	// Errors holds the error from each failed API call, keyed by collector
+	Errors     map[string]string
+	Downstream []channelStatus
+	Upstream   []channelStatus
+	UsOfdm     []hitron.OFDMUsChannel
+	DsOfdm     []hitron.OFDMReceiver
+	Clients    []clientStatus
+	Duration   time.Duration
+	Up         bool
+}
+
+// channelStatus is a bonded channel, along with its signal health
+type channelStatus struct {
+	hitron.PortInfo
+	Health healthState
+	// Checked is set when the channel's levels were checked against thresholds
+	Checked bool
+}
+
+// clientStatus is a WiFi client, identified according to the wifi_clients
+// settings
+type clientStatus struct {
+	Band     string
+	SSID     string
+	Hostname string
+	MAC      string
+	PhyMode  string
+	RSSI     int
+}
+
+// recordScrape records the result of a scrape which started at start
+func (s *targetState) recordScrape(start time.Time, errs map[string]string, up bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.LastScrape = start
+	s.status.Duration = time.Since(start)
+	s.status.Errors = errs
+	s.status.Up = up
+}
+
+// statusSnapshot returns a copy of the target's status. The tables are
+// replaced rather than modified, so they can be shared.
+func (s *targetState) statusSnapshot() targetStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+// statusPage is the data for the status page template
+type statusPage struct {
+	Config  string
+	Targets []statusTarget
+}
+
+type statusTarget struct {
+	Host   string
+	Status targetStatus
+}
+
+// statusHandler serves an overview of each configured target's last scrape,
+// and the loaded config, with secrets redacted
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	sc.RLock()
+	conf := sc.C
+	sc.RUnlock()
+
+	page := statusPage{}
+
+	var hosts []string
+
+	if conf != nil {
+		if conf.Host != "" {
+			hosts = append(hosts, conf.Host)
+		}
+
+		hosts = append(hosts, slices.Collect(maps.Keys(conf.Targets))...)
+
+		b, err := yaml.Marshal(redactConfig(conf))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error marshalling config", "err", err)
+		}
+
+		page.Config = string(b)
+	}
+
+	slices.Sort(hosts)
+
+	for _, host := range slices.Compact(hosts) {
+		page.Targets = append(page.Targets, statusTarget{
+			Host:   host,
+			Status: states.get(host).statusSnapshot(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := statusTemplate.Execute(w, page); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering status page", "err", err)
+	}
+}
+
+// redactConfig returns a copy of the config for display, with each
+// password_file and password_command redacted as well as the passwords, since
+// they reveal where the passwords can be read from. The WiFi clients
+// allow_list and names_file are redacted too, as they identify devices.
+func redactConfig(c *config) *config {
+	out := *c
+	out.PasswordFile = secret(c.PasswordFile).redact()
+	out.PasswordCommand = redactList(c.PasswordCommand)
+
+	out.Auths = make(map[string]authConfig, len(c.Auths))
+
+	for name, a := range c.Auths {
+		a.PasswordFile = secret(a.PasswordFile).redact()
+		a.PasswordCommand = redactList(a.PasswordCommand)
+		out.Auths[name] = a
+	}
+
+	out.WiFiClients.AllowList = redactList(c.WiFiClients.AllowList)
+	out.WiFiClients.NamesFile = secret(c.WiFiClients.NamesFile).redact()
+
+	return &out
+}
+
+// redactList replaces a non-empty list with a single redacted entry, so that
+// not even the number of entries is shown
+func redactList(l []string) []string {
+	if len(l) == 0 {
+		return nil
+	}
+
+	return []string{secret("list").redact()}
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"mhz": func(hz int) float64 {
+		//nolint:gomnd
+		return float64(hz) / 1e6
+	},
+	"ago": func(t time.Time) time.Duration {
+		return time.Since(t).Round(time.Second)
+	},
+	"ms": func(d time.Duration) time.Duration {
+		return d.Round(time.Millisecond)
+	},
+}).Parse(statusHTML))
+
+const statusHTML = `<!DOCTYPE html>
+<html>
+<head>
+	<title>Hitron CODA Cable Modem Exporter</title>
+	<meta http-equiv="refresh" content="60">
+	<style>
+		body {
+			font-family: sans-serif;
+		}
+		label {
+			display: inline-block;
+			width: 75px;
+		}
+		form label, form input {
+			margin: 10px;
+		}
+		table {
+			border-collapse: collapse;
+			margin-bottom: 1em;
+		}
+		th, td {
+			border: 1px solid #ccc;
+			padding: 2px 8px;
+			text-align: right;
+		}
+		th {
+			background: #eee;
+		}
+		.ok {
+			background: #c8e6c9;
+		}
+		.warn {
+			background: #ffe082;
+		}
+		.critical, .down {
+			background: #ef9a9a;
+		}
+		.up {
+			background: #c8e6c9;
+		}
+		.error {
+			color: #b71c1c;
+		}
+	</style>
+</head>
+<body>
+	<h1>Hitron CODA Cable Modem Exporter</h1>
+	<form action="/scrape">
+		<label>Target:</label> <input type="text" name="target" placeholder="192.168.0.1"><br>
+		<input type="submit" value="/scrape">
+	</form>
+	<p><a href="/metrics">Exporter metrics</a></p>
+{{- range .Targets}}
+	<h2>{{.Host}}</h2>
+	{{- with .Status}}
+	{{- if .LastScrape.IsZero}}
+	<p>Not scraped yet</p>
+	{{- else}}
+	<p>
+		<span class="{{if .Up}}up{{else}}down{{end}}">{{if .Up}}Up{{else}}Down{{end}}</span>
+		- last scraped at {{.LastScrape.Format "2006-01-02 15:04:05 MST"}} ({{ago .LastScrape}} ago), in {{ms .Duration}}
+	</p>
+	{{- if .Errors}}
+	<ul class="error">
+		{{- range $collector, $err := .Errors}}
+		<li>{{$collector}}: {{$err}}</li>
+		{{- end}}
+	</ul>
+	{{- end}}
+	{{- end}}
+	{{- with .Downstream}}
+	<h3>Downstream channels</h3>
+	<table>
+		<tr><th>Port</th><th>Channel</th><th>Modulation</th><th>Frequency (MHz)</th><th>Power (dBmV)</th><th>SNR (dB)</th><th>Corrected</th><th>Uncorrected</th></tr>
+		{{- range .}}
+		<tr{{if .Checked}} class="{{.Health}}"{{end}}><td>{{.PortID}}</td><td>{{.ChannelID}}</td><td>{{.Modulation}}</td><td>{{printf "%.1f" (mhz .Frequency)}}</td><td>{{printf "%.1f" .SignalStrength}}</td><td>{{printf "%.1f" .SNR}}</td><td>{{.Correcteds}}</td><td>{{.Uncorrect}}</td></tr>
+		{{- end}}
+	</table>
+	{{- end}}
+	{{- with .Upstream}}
+	<h3>Upstream channels</h3>
+	<table>
+		<tr><th>Port</th><th>Channel</th><th>Modulation</th><th>Frequency (MHz)</th><th>Power (dBmV)</th><th>Bandwidth (bps)</th></tr>
+		{{- range .}}
+		<tr{{if .Checked}} class="{{.Health}}"{{end}}><td>{{.PortID}}</td><td>{{.ChannelID}}</td><td>{{.Modulation}}</td><td>{{printf "%.1f" (mhz .Frequency)}}</td><td>{{printf "%.1f" .SignalStrength}}</td><td>{{.Bandwidth}}</td></tr>
+		{{- end}}
+	</table>
+	{{- end}}
+	{{- with .DsOfdm}}
+	<h3>Downstream OFDM receivers</h3>
+	<table>
+		<tr><th>Receiver</th><th>FFT type</th><th>PLC power (dBmV)</th><th>First subcarrier (MHz)</th></tr>
+		{{- range .}}
+		<tr><td>{{.ID}}</td><td>{{.FFTType}}</td><td>{{printf "%.1f" .PLCPower}}</td><td>{{printf "%.1f" (mhz .SubcarrierFreq)}}</td></tr>
+		{{- end}}
+	</table>
+	{{- end}}
+	{{- with .UsOfdm}}
+	<h3>Upstream OFDMA channels</h3>
+	<table>
+		<tr><th>Channel</th><th>Enabled</th><th>FFT size</th><th>Bandwidth (Hz)</th><th>Reported power (qdBmV)</th><th>Target power (qdBmV)</th></tr>
+		{{- range .}}
+		<tr><td>{{.ID}}</td><td>{{.Enable}}</td><td>{{.FFTSize}}</td><td>{{printf "%.0f" .ChannelBw}}</td><td>{{printf "%.1f" .RepPower}}</td><td>{{printf "%.1f" .RepPower1_6}}</td></tr>
+		{{- end}}
+	</table>
+	{{- end}}
+	{{- with .Clients}}
+	<h3>WiFi clients</h3>
+	<table>
+		<tr><th>Hostname</th><th>MAC address</th><th>Band</th><th>SSID</th><th>PHY mode</th><th>RSSI (dB)</th></tr>
+		{{- range .}}
+		<tr><td>{{.Hostname}}</td><td>{{.MAC}}</td><td>{{.Band}}</td><td>{{.SSID}}</td><td>{{.PhyMode}}</td><td>{{.RSSI}}</td></tr>
+		{{- end}}
+	</table>
+	{{- end}}
+	{{- end}}
+{{- end}}
+{{- with .Config}}
+	<h2>Config</h2>
+	<pre>{{.}}</pre>
+{{- end}}
+</body>
+</html>
+`
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getStatus(t *testing.T) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	statusHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	return rec.Body.String()
+}
+
+func TestStatusPage(t *testing.T) {
+	fake, conf := newFakeModem(t)
+
+	sc.Lock()
+	sc.C = conf
+	sc.Unlock()
+
+	body := getStatus(t)
+	assert.Contains(t, body, "<h2>"+conf.Host+"</h2>")
+	assert.Contains(t, body, "Not scraped yet")
+
+	scrape(t, conf, "")
+
+	body = getStatus(t)
+	assert.NotContains(t, body, "Not scraped yet")
+	assert.Contains(t, body, `<span class="up">Up</span>`)
+	assert.Contains(t, body, "<h3>Downstream channels</h3>")
+	assert.Contains(t, body, `<tr class="ok"><td>1</td><td>9</td><td>256QAM</td>`)
+	assert.Contains(t, body, "<h3>Upstream channels</h3>")
+	assert.Contains(t, body, "<h3>Downstream OFDM receivers</h3>")
+	assert.Contains(t, body, "<h3>Upstream OFDMA channels</h3>")
+	assert.Contains(t, body, "<td>laptop</td>")
+
+	// the config is shown, without the password
+	assert.Contains(t, body, "username: "+conf.Username)
+	assert.Contains(t, body, "password: &lt;secret&gt;")
+	assert.NotContains(t, body, "password: "+string(conf.Password))
+
+	// targets are only listed when they're configured
+	states.get("10.0.0.1")
+
+	body = getStatus(t)
+	assert.NotContains(t, body, "10.0.0.1")
+
+	// the tables from the last successful scrape are kept, along with the error
+	fake.FailAuth = true
+
+	// drop the session, so the next scrape has to log in
+	sessions.mu.Lock()
+	sessions.sessions = map[string]*session{}
+	sessions.mu.Unlock()
+
+	scrape(t, conf, "")
+
+	body = getStatus(t)
+	assert.Contains(t, body, `<span class="down">Down</span>`)
+	assert.Contains(t, body, "<li>login: ")
+	assert.Contains(t, body, "<h3>Downstream channels</h3>")
+}
+
+func TestStatusPageRedactsConfig(t *testing.T) {
+	sc.Lock()
+	sc.C = &config{
+		Host:         "192.168.0.1",
+		PasswordFile: "/run/secrets/hitron",
+		Auths: map[string]authConfig{
+			"office": {Username: "cusadmin", PasswordCommand: []string{"pass", "show", "hitron/office"}},
+		},
+		WiFiClients: wifiClientsConfig{
+			AllowList: []string{"a4:83:e7:00:00:01", "daves-laptop"},
+			NamesFile: "/etc/hitron_coda_exporter/names.yaml",
+		},
+	}
+	sc.Unlock()
+
+	body := getStatus(t)
+	assert.Contains(t, body, "password_file: &lt;secret&gt;")
+	assert.NotContains(t, body, "/run/secrets/hitron")
+	assert.NotContains(t, body, "hitron/office")
+	assert.Contains(t, body, "username: cusadmin")
+
+	assert.Contains(t, body, "names_file: &lt;secret&gt;")
+	assert.NotContains(t, body, "names.yaml")
+	assert.NotContains(t, body, "a4:83:e7:00:00:01")
+	assert.NotContains(t, body, "daves-laptop")
+
+	// the loaded config isn't modified
+	assert.Equal(t, "/run/secrets/hitron", sc.C.PasswordFile)
+	assert.Equal(t, []string{"pass", "show", "hitron/office"}, sc.C.Auths["office"].PasswordCommand)
+	assert.Equal(t, []string{"a4:83:e7:00:00:01", "daves-laptop"}, sc.C.WiFiClients.AllowList)
+}
+
+func TestStatusPageNotFound(t *testing.T) {
+	rec := httptest.NewRecorder()
+	statusHandler(rec, httptest.NewRequest(http.MethodGet, "/nonexistent", nil))
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
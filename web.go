@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// webConfig is the web config file, in the format used by Prometheus and its
+// exporters (https://prometheus.io/docs/prometheus/latest/configuration/https/).
+// The TLS certificate, client CA, minimum TLS version, and basic auth users
+// are supported.
+type webConfig struct {
+	TLSServerConfig *webTLSConfig     `yaml:"tls_server_config"`
+	BasicAuthUsers  map[string]string `yaml:"basic_auth_users"`
+}
+
+type webTLSConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	MinVersion     string `yaml:"min_version"`
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"TLS10": tls.VersionTLS10,
+		"TLS11": tls.VersionTLS11,
+		"TLS12": tls.VersionTLS12,
+		"TLS13": tls.VersionTLS13,
+	}
+
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"":                           tls.NoClientCert,
+		"NoClientCert":               tls.NoClientCert,
+		"RequestClientCert":          tls.RequestClientCert,
+		"RequireAnyClientCert":       tls.RequireAnyClientCert,
+		"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+		"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+	}
+
+	// fakeHash is checked for unknown users, so that they take as long to
+	// reject as known users with the wrong password
+	fakeHash = []byte("$2a$10$fw7ygLj2x7k8bKGmO6xA3Obi.fuR9jlp.01Oh/HBkhi.V.tvGmsNq")
+)
+
+// authCacheSize is how many successful basic auth checks are remembered
+const authCacheSize = 100
+
+// loadedWebConfig is the web config, with the certificates loaded
+type loadedWebConfig struct {
+	tls   *tls.Config
+	users map[string][]byte
+
+	// authCache holds the hashes of credentials which have passed the
+	// (deliberately slow) bcrypt check, so that it isn't repeated on every
+	// scrape. It's discarded along with the config on reload.
+	authCache map[[sha256.Size]byte]struct{}
+	authMu    sync.Mutex
+}
+
+func loadWebConfig(path string) (*loadedWebConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := webConfig{}
+
+	// unknown fields are errors, so that a typo can't silently disable auth
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	l := &loadedWebConfig{users: make(map[string][]byte, len(c.BasicAuthUsers))}
+
+	for user, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash for user %q: %w", user, err)
+		}
+
+		l.users[user] = []byte(hash)
+	}
+
+	if c.TLSServerConfig != nil {
+		// paths are relative to the web config file
+		l.tls, err = c.TLSServerConfig.tlsConfig(filepath.Dir(path))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+func (c webTLSConfig) tlsConfig(dir string) (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls_server_config requires both cert_file and key_file")
+	}
+
+	path := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+
+		return filepath.Join(dir, p)
+	}
+
+	cert, err := tls.LoadX509KeyPair(path(c.CertFile), path(c.KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// this config replaces the server's own for each connection, so the
+		// protocols it would have offered need setting here too
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown min_version %q", c.MinVersion)
+		}
+
+		cfg.MinVersion = v
+	}
+
+	authType, ok := clientAuthTypes[c.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("unknown client_auth_type %q", c.ClientAuthType)
+	}
+
+	cfg.ClientAuth = authType
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(path(c.ClientCAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client_ca_file: %w", err)
+		}
+
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client_ca_file %s", c.ClientCAFile)
+		}
+	}
+
+	if cfg.ClientCAs == nil && (authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert) {
+		return nil, fmt.Errorf("client_auth_type %s requires a client_ca_file", c.ClientAuthType)
+	}
+
+	return cfg, nil
+}
+
+// webServer serves the exporter's endpoints, with TLS and basic auth set up
+// from the web config file, if there is one
+type webServer struct {
+	config atomic.Pointer[loadedWebConfig]
+	path   string
+}
+
+func newWebServer(path string) (*webServer, error) {
+	s := &webServer{path: path}
+
+	if path == "" {
+		s.config.Store(&loadedWebConfig{})
+
+		return s, nil
+	}
+
+	return s, s.reload()
+}
+
+// reload re-reads the web config file, so that renewed certificates and
+// changed users take effect. The current config is kept if the file is
+// invalid.
+func (s *webServer) reload() error {
+	if s.path == "" {
+		return nil
+	}
+
+	c, err := loadWebConfig(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to load web config file %s: %w", s.path, err)
+	}
+
+	if old := s.config.Load(); old != nil && (old.tls == nil) != (c.tls == nil) {
+		return errors.New("enabling or disabling TLS requires a restart")
+	}
+
+	s.config.Store(c)
+
+	return nil
+}
+
+// handler wraps h with basic auth, when there are users configured
+func (s *webServer) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := s.config.Load()
+		if len(c.users) > 0 && !c.checkBasicAuth(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hitron_coda_exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (c *loadedWebConfig) checkBasicAuth(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + pass))
+
+	c.authMu.Lock()
+	_, cached := c.authCache[key]
+	c.authMu.Unlock()
+
+	if cached {
+		return true
+	}
+
+	hash, known := c.users[user]
+	if !known {
+		hash = fakeHash
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(pass))
+	if !known || err != nil {
+		return false
+	}
+
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.authCache == nil {
+		c.authCache = map[[sha256.Size]byte]struct{}{}
+	}
+
+	// make room by forgetting an arbitrary entry
+	if len(c.authCache) >= authCacheSize {
+		for k := range c.authCache {
+			delete(c.authCache, k)
+
+			break
+		}
+	}
+
+	c.authCache[key] = struct{}{}
+
+	return true
+}
+
+// serve srv on l, with TLS if it's configured
+func (s *webServer) serve(l net.Listener, srv *http.Server) error {
+	srv.Handler = s.handler(srv.Handler)
+
+	if s.config.Load().tls == nil {
+		slog.Info("TLS is disabled", "address", l.Addr().String())
+
+		return srv.Serve(l)
+	}
+
+	// the current config is used for each new connection, so reloaded
+	// certificates are picked up without a restart. GetCertificate is set too,
+	// as ServeTLS needs a certificate before any client connects.
+	srv.TLSConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &s.config.Load().tls.Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.config.Load().tls, nil
+		},
+	}
+
+	slog.Info("TLS is enabled", "address", l.Addr().String())
+
+	return srv.ServeTLS(l, "", "")
+}
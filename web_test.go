@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeCert writes a self-signed certificate and key for localhost into dir,
+// returning the certificate
+func writeCert(t *testing.T, dir, name string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert
+}
+
+func writeWebConfig(t *testing.T, path, content string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "server")
+
+	path := filepath.Join(dir, "web.yml")
+
+	writeWebConfig(t, path, "")
+	c, err := loadWebConfig(path)
+	require.NoError(t, err)
+	assert.Nil(t, c.tls)
+	assert.Empty(t, c.users)
+
+	writeWebConfig(t, path, `
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  min_version: TLS13
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: server.crt
+`)
+	c, err = loadWebConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), c.tls.MinVersion)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, c.tls.ClientAuth)
+	assert.NotNil(t, c.tls.ClientCAs)
+
+	invalid := map[string]string{
+		"unknown field":      "basic_auth_user:\n  alice: x\n",
+		"invalid hash":       "basic_auth_users:\n  alice: password\n",
+		"missing key":        "tls_server_config:\n  cert_file: server.crt\n",
+		"missing cert":       "tls_server_config:\n  cert_file: nope.crt\n  key_file: server.key\n",
+		"unknown version":    "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  min_version: TLS14\n",
+		"unknown auth type":  "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: Maybe\n",
+		"verify without CAs": "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: RequireAndVerifyClientCert\n",
+	}
+
+	for name, content := range invalid {
+		writeWebConfig(t, path, content)
+		_, err = loadWebConfig(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestWebServerBasicAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.yml")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	writeWebConfig(t, path, "basic_auth_users:\n  alice: "+string(hash)+"\n")
+
+	ws, err := newWebServer(path)
+	require.NoError(t, err)
+
+	h := ws.handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	get := func(user, pass string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("", ""))
+	assert.Equal(t, http.StatusUnauthorized, get("alice", "wrong"))
+	assert.Equal(t, http.StatusUnauthorized, get("bob", "secret"))
+	assert.Equal(t, http.StatusOK, get("alice", "secret"))
+
+	// successful checks are cached, but failed ones aren't
+	c := ws.config.Load()
+	assert.Len(t, c.authCache, 1)
+	assert.Equal(t, http.StatusOK, get("alice", "secret"))
+	assert.Equal(t, http.StatusUnauthorized, get("alice", "wrong"))
+	assert.Len(t, c.authCache, 1)
+
+	// users can be removed on reload
+	writeWebConfig(t, path, "")
+	require.NoError(t, ws.reload())
+	assert.Equal(t, http.StatusOK, get("", ""))
+
+	// an invalid file doesn't replace the current config
+	writeWebConfig(t, path, "basic_auth_users:\n  alice: password\n")
+	require.Error(t, ws.reload())
+	assert.Equal(t, http.StatusOK, get("", ""))
+
+	// and no web config means no auth
+	ws, err = newWebServer("")
+	require.NoError(t, err)
+	require.NoError(t, ws.reload())
+	assert.Nil(t, ws.config.Load().tls)
+}
+
+func TestWebServerTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "web.yml")
+
+	first := writeCert(t, dir, "first")
+	second := writeCert(t, dir, "second")
+
+	writeWebConfig(t, path, "tls_server_config:\n  cert_file: first.crt\n  key_file: first.key\n")
+
+	ws, err := newWebServer(path)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+
+	go func() { _ = ws.serve(l, srv) }()
+
+	t.Cleanup(func() { _ = srv.Close() })
+
+	// the certificate the server presents to a new connection
+	serverCert := func() *x509.Certificate {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		require.NoError(t, err)
+
+		defer conn.Close()
+
+		// HTTP/2 is still offered
+		assert.Equal(t, "h2", conn.ConnectionState().NegotiatedProtocol)
+
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+
+	assert.Equal(t, first.Raw, serverCert().Raw)
+
+	writeWebConfig(t, path, "tls_server_config:\n  cert_file: second.crt\n  key_file: second.key\n")
+	require.NoError(t, ws.reload())
+	assert.Equal(t, second.Raw, serverCert().Raw)
+
+	// TLS can't be turned off without a restart
+	writeWebConfig(t, path, "")
+	require.Error(t, ws.reload())
+	assert.Equal(t, second.Raw, serverCert().Raw)
+}